@@ -0,0 +1,20 @@
+package ai
+
+import (
+	"net/http"
+
+	"bot/config"
+)
+
+const defaultDeepSeekEndpoint = "https://api.deepseek.com/chat/completions"
+
+// NewDeepSeek は DeepSeek API 用のプロバイダーを生成します。
+// DeepSeek は OpenAI 互換のため、エンドポイントの既定値のみが異なります。
+func NewDeepSeek(cfg *config.AIConfig, client *http.Client) *OpenAI {
+	p := NewOpenAI(cfg, client)
+	p.name = "deepseek"
+	if cfg.Endpoint == "" {
+		p.endpoint = defaultDeepSeekEndpoint
+	}
+	return p
+}
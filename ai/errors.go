@@ -0,0 +1,160 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorKind はAPIエラーの分類です。リトライ可否の判断に使います。
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	KindRateLimit
+	KindAuth
+	KindContextLength
+	KindTransient
+	KindBadRequest
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindRateLimit:
+		return "rate_limit"
+	case KindAuth:
+		return "auth"
+	case KindContextLength:
+		return "context_length"
+	case KindTransient:
+		return "transient"
+	case KindBadRequest:
+		return "bad_request"
+	default:
+		return "unknown"
+	}
+}
+
+// errors.Is で分類を判定するためのエラー値
+var (
+	ErrRateLimited   = errors.New("APIのレート制限に達しました")
+	ErrAuth          = errors.New("APIの認証に失敗しました")
+	ErrContextLength = errors.New("入力がモデルのコンテキスト長を超えています")
+	ErrTransient     = errors.New("APIの一時的なエラーです")
+	ErrCircuitOpen   = errors.New("API呼び出しは一時停止中です")
+)
+
+// APIError はAPIから返されたエラーを分類したものです。
+type APIError struct {
+	Kind       ErrorKind
+	StatusCode int
+	// RetryAfter はサーバーが Retry-After で指定した待機時間（指定がなければ0）
+	RetryAfter time.Duration
+	Message    string
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("APIエラー (%s", e.Kind)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(", status %d", e.StatusCode)
+	}
+	msg += ")"
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.Kind == KindRateLimit
+	case ErrAuth:
+		return e.Kind == KindAuth
+	case ErrContextLength:
+		return e.Kind == KindContextLength
+	case ErrTransient:
+		return e.Kind == KindTransient
+	}
+	return false
+}
+
+// Retryable はリトライで回復する可能性があるかどうかを返します。
+func (e *APIError) Retryable() bool {
+	return e.Kind == KindRateLimit || e.Kind == KindTransient
+}
+
+// contextLengthHints はコンテキスト長超過を示すエラーメッセージの断片です。
+var contextLengthHints = []string{
+	"context_length_exceeded",
+	"maximum context length",
+	"context length",
+	"too many tokens",
+	"prompt is too long",
+}
+
+// classifyResponse は200以外のレスポンスを APIError に変換します。
+func classifyResponse(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		Message:    strings.TrimSpace(string(body)),
+	}
+
+	lower := strings.ToLower(apiErr.Message)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		apiErr.Kind = KindRateLimit
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		apiErr.Kind = KindAuth
+	case resp.StatusCode == http.StatusRequestEntityTooLarge || containsAny(lower, contextLengthHints):
+		apiErr.Kind = KindContextLength
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500:
+		apiErr.Kind = KindTransient
+	case resp.StatusCode >= 400:
+		apiErr.Kind = KindBadRequest
+	}
+	return apiErr
+}
+
+// transportError はネットワークエラーやタイムアウトを一時的なエラーとして包みます。
+func transportError(err error) *APIError {
+	return &APIError{Kind: KindTransient, Message: "APIリクエストに失敗しました", Err: err}
+}
+
+// parseRetryAfter は秒数またはHTTP日付形式の Retry-After を解釈します。
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
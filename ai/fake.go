@@ -0,0 +1,51 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Fake はAPIを呼ばずに応答を返すプロバイダーです。
+// ai.provider: "fake" でオフライン動作させる場合や、テストで応答を差し替える場合に使います。
+type Fake struct {
+	// Respond が nil の場合は入力を元にした固定の応答を返す
+	Respond func(req Request) (string, error)
+
+	mu    sync.Mutex
+	calls []Request
+}
+
+func (p *Fake) Name() string {
+	return "fake"
+}
+
+func (p *Fake) Complete(ctx context.Context, req Request) (string, error) {
+	p.mu.Lock()
+	p.calls = append(p.calls, req)
+	p.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if p.Respond != nil {
+		return p.Respond(req)
+	}
+
+	var prompt string
+	if len(req.Messages) > 0 {
+		prompt = req.Messages[len(req.Messages)-1].Content
+	}
+	// ダイジェスト用のプロンプトには JSON で応答する
+	if strings.Contains(prompt, `"digest"`) {
+		return `{"summaries":[],"digest":"(fake) ダイジェストは生成されていません"}`, nil
+	}
+	return "(fake) 要約は生成されていません", nil
+}
+
+// Calls はこれまでに受け取ったリクエストを返します。
+func (p *Fake) Calls() []Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Request(nil), p.calls...)
+}
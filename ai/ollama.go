@@ -0,0 +1,101 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"bot/config"
+)
+
+const defaultOllamaEndpoint = "http://localhost:11434"
+
+type ollamaRequest struct {
+	Model    string        `json:"model"`
+	Messages []Message     `json:"messages"`
+	Stream   bool          `json:"stream"`
+	Options  ollamaOptions `json:"options"`
+}
+
+type ollamaOptions struct {
+	Temperature float64 `json:"temperature"`
+	NumPredict  int     `json:"num_predict,omitempty"`
+}
+
+type ollamaResponse struct {
+	Message Message `json:"message"`
+}
+
+// Ollama はローカルの Ollama ネイティブAPI (/api/chat) を呼び出します。
+type Ollama struct {
+	endpoint string
+	model    string
+	client   *http.Client
+}
+
+func NewOllama(cfg *config.AIConfig, client *http.Client) *Ollama {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultOllamaEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/api/chat") {
+		endpoint += "/api/chat"
+	}
+	return &Ollama{
+		endpoint: endpoint,
+		model:    cfg.Model,
+		client:   client,
+	}
+}
+
+func (p *Ollama) Name() string {
+	return "ollama"
+}
+
+func (p *Ollama) Complete(ctx context.Context, r Request) (string, error) {
+	jsonBody, err := json.Marshal(ollamaRequest{
+		Model:    p.model,
+		Messages: r.Messages,
+		Stream:   false,
+		Options: ollamaOptions{
+			Temperature: r.Temperature,
+			NumPredict:  r.MaxTokens,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("リクエストのマーシャリングに失敗しました: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.endpoint, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("リクエストの作成に失敗しました: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", transportError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", classifyResponse(resp)
+	}
+
+	var response ollamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("レスポンスの解析に失敗しました: %w", err)
+	}
+	if response.Message.Content == "" {
+		return "", fmt.Errorf("有効な要約が生成されませんでした")
+	}
+
+	return response.Message.Content, nil
+}
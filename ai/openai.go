@@ -0,0 +1,101 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"bot/config"
+)
+
+const defaultOpenAIEndpoint = "https://api.openai.com/v1/chat/completions"
+
+type chatRequest struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature float64   `json:"temperature"`
+	MaxTokens   int       `json:"max_tokens"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+// OpenAI は OpenAI 互換の /chat/completions エンドポイントを呼び出します。
+type OpenAI struct {
+	name     string
+	endpoint string
+	apiKey   string
+	model    string
+	client   *http.Client
+}
+
+func NewOpenAI(cfg *config.AIConfig, client *http.Client) *OpenAI {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultOpenAIEndpoint
+	}
+	return &OpenAI{
+		name:     "openai",
+		endpoint: endpoint,
+		apiKey:   cfg.APIKey,
+		model:    cfg.Model,
+		client:   client,
+	}
+}
+
+func (p *OpenAI) Name() string {
+	return p.name
+}
+
+func (p *OpenAI) Complete(ctx context.Context, r Request) (string, error) {
+	jsonBody, err := json.Marshal(chatRequest{
+		Model:       p.model,
+		Messages:    r.Messages,
+		Temperature: r.Temperature,
+		MaxTokens:   r.MaxTokens,
+	})
+	if err != nil {
+		return "", fmt.Errorf("リクエストのマーシャリングに失敗しました: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.endpoint, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("リクエストの作成に失敗しました: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", transportError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", classifyResponse(resp)
+	}
+
+	var response chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("レスポンスの解析に失敗しました: %w", err)
+	}
+
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("有効な要約が生成されませんでした")
+	}
+
+	return response.Choices[0].Message.Content, nil
+}
@@ -0,0 +1,57 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"bot/config"
+)
+
+// Message はチャット形式のメッセージです。
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request はプロバイダーに依存しない生成リクエストです。
+type Request struct {
+	Messages    []Message
+	Temperature float64
+	MaxTokens   int
+}
+
+// Provider は文章生成APIの実装です。ai.provider の値で切り替えます。
+type Provider interface {
+	Name() string
+	Complete(ctx context.Context, req Request) (string, error)
+}
+
+// defaultTimeout は ai.timeout 未設定時の1リクエストあたりのタイムアウト
+const defaultTimeout = 60 * time.Second
+
+// NewProvider は設定に応じたプロバイダーを生成し、リトライとサーキットブレーカーで包みます。
+func NewProvider(cfg *config.AIConfig) (Provider, error) {
+	timeout := time.Duration(cfg.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	var p Provider
+	switch strings.ToLower(cfg.Provider) {
+	case "", "deepseek":
+		p = NewDeepSeek(cfg, client)
+	case "openai":
+		p = NewOpenAI(cfg, client)
+	case "ollama":
+		p = NewOllama(cfg, client)
+	case "fake":
+		p = &Fake{}
+	default:
+		return nil, fmt.Errorf("未対応のAIプロバイダーです: %s", cfg.Provider)
+	}
+	return NewResilient(p, cfg), nil
+}
@@ -0,0 +1,201 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"bot/config"
+	"bot/status"
+)
+
+const (
+	defaultMaxRetries       = 3
+	defaultRetryBaseDelay   = time.Second
+	defaultRetryMaxDelay    = 30 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 5 * time.Minute
+)
+
+// Resilient は Provider をリトライ・指数バックオフ・サーキットブレーカーで包みます。
+// 呼び出し結果は status.SetAIStatus に報告されます。
+type Resilient struct {
+	inner      Provider
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	threshold  int
+	cooldown   time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+	ai        status.AIStatus
+}
+
+func NewResilient(inner Provider, cfg *config.AIConfig) *Resilient {
+	r := &Resilient{
+		inner:      inner,
+		maxRetries: cfg.MaxRetries,
+		baseDelay:  cfg.RetryBaseDelay,
+		maxDelay:   cfg.RetryMaxDelay,
+		threshold:  cfg.BreakerThreshold,
+		cooldown:   cfg.BreakerCooldown,
+		ai:         status.AIStatus{Provider: inner.Name(), State: status.AIStateOK},
+	}
+	switch {
+	case cfg.MaxRetries < 0: // 負の値でリトライ無効
+		r.maxRetries = 0
+	case cfg.MaxRetries == 0:
+		r.maxRetries = defaultMaxRetries
+	}
+	if r.baseDelay <= 0 {
+		r.baseDelay = defaultRetryBaseDelay
+	}
+	if r.maxDelay <= 0 {
+		r.maxDelay = defaultRetryMaxDelay
+	}
+	if r.threshold <= 0 {
+		r.threshold = defaultBreakerThreshold
+	}
+	if r.cooldown <= 0 {
+		r.cooldown = defaultBreakerCooldown
+	}
+	status.SetAIStatus(r.ai)
+	return r
+}
+
+func (r *Resilient) Name() string {
+	return r.inner.Name()
+}
+
+func (r *Resilient) Complete(ctx context.Context, req Request) (string, error) {
+	if err := r.allow(); err != nil {
+		return "", err
+	}
+
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		out, err := r.inner.Complete(ctx, req)
+		if err == nil {
+			r.recordSuccess()
+			return out, nil
+		}
+		if callerDone(ctx, err) {
+			// 呼び出し側のタイムアウトやシャットダウンはAPIの失敗として数えない
+			r.release()
+			return "", err
+		}
+		lastErr = err
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || !apiErr.Retryable() || attempt >= r.maxRetries {
+			break
+		}
+
+		wait := r.backoff(attempt, apiErr.RetryAfter)
+		select {
+		case <-ctx.Done():
+			r.release()
+			return "", ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	r.recordFailure(lastErr)
+	return "", lastErr
+}
+
+// backoff は attempt 回目の失敗後の待機時間を返します。Retry-After があれば優先します。
+func (r *Resilient) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if retryAfter > r.maxDelay {
+			return r.maxDelay
+		}
+		return retryAfter
+	}
+	d := r.baseDelay << attempt
+	if d <= 0 || d > r.maxDelay {
+		d = r.maxDelay
+	}
+	// 同時に再試行が集中しないよう最大25%のゆらぎを加える
+	return d - time.Duration(rand.Int63n(int64(d)/4+1))
+}
+
+// allow はサーキットが開いている間は ErrCircuitOpen を返します。
+// クールダウン経過後は1件だけ試行を通し、結果で閉じるか再度開きます。
+func (r *Resilient) allow() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.openUntil.IsZero() {
+		return nil
+	}
+	if time.Now().Before(r.openUntil) || r.probing {
+		return &APIError{Kind: KindUnknown, Message: "サーキットブレーカー作動中", Err: ErrCircuitOpen}
+	}
+	r.probing = true
+	return nil
+}
+
+// callerDone は err が呼び出し側の ctx のキャンセルまたは期限切れによるものかどうかを返します。
+func callerDone(ctx context.Context, err error) bool {
+	return ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded))
+}
+
+// release は結果を記録せずに試行を終えます。半開状態の試行だった場合は次の呼び出しで再び試行します。
+func (r *Resilient) release() {
+	r.mu.Lock()
+	r.probing = false
+	r.mu.Unlock()
+}
+
+func (r *Resilient) recordSuccess() {
+	r.mu.Lock()
+	r.failures = 0
+	r.openUntil = time.Time{}
+	r.probing = false
+	r.ai.State = status.AIStateOK
+	r.ai.ConsecutiveFailures = 0
+	r.ai.LastSuccessAt = time.Now()
+	r.ai.OpenUntil = time.Time{}
+	st := r.ai
+	r.mu.Unlock()
+
+	status.SetAIStatus(st)
+}
+
+func (r *Resilient) recordFailure(err error) {
+	r.mu.Lock()
+	// 入力が大きすぎる等、APIの健全性と無関係なエラーはブレーカーの対象外
+	var apiErr *APIError
+	countable := !(errors.As(err, &apiErr) && (apiErr.Kind == KindContextLength || apiErr.Kind == KindBadRequest))
+	if countable {
+		r.failures++
+	}
+
+	r.ai.LastError = err.Error()
+	r.ai.LastErrorAt = time.Now()
+	r.ai.ConsecutiveFailures = r.failures
+	switch {
+	case countable && (r.probing || r.failures >= r.threshold):
+		r.openUntil = time.Now().Add(r.cooldown)
+		r.ai.State = status.AIStateOpen
+		r.ai.OpenUntil = r.openUntil
+	case r.probing:
+		// 試行でAPIが応答したのでサーキットを閉じる
+		r.openUntil = time.Time{}
+		r.ai.State = status.AIStateOK
+		r.ai.OpenUntil = time.Time{}
+	case r.failures > 0:
+		r.ai.State = status.AIStateDegraded
+	}
+	r.probing = false
+	st := r.ai
+	r.mu.Unlock()
+
+	status.SetAIStatus(st)
+}
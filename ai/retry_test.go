@@ -0,0 +1,246 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"bot/config"
+	"bot/status"
+)
+
+func transient() error {
+	return &APIError{Kind: KindTransient, StatusCode: http.StatusBadGateway}
+}
+
+func TestBackoff(t *testing.T) {
+	r := NewResilient(&Fake{}, &config.AIConfig{RetryBaseDelay: time.Second, RetryMaxDelay: 10 * time.Second})
+
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		for i := 0; i < 20; i++ {
+			got := r.backoff(attempt, 0)
+			// 最大25%のゆらぎで短くなる
+			if got > want || got < want-want/4 {
+				t.Fatalf("backoff(%d) = %v, want %v-%v", attempt, got, want-want/4, want)
+			}
+		}
+	}
+	if got := r.backoff(63, 0); got > 10*time.Second || got <= 0 {
+		t.Fatalf("backoff(63) = %v, want capped at max delay", got)
+	}
+}
+
+func TestBackoffRetryAfter(t *testing.T) {
+	r := NewResilient(&Fake{}, &config.AIConfig{RetryBaseDelay: time.Second, RetryMaxDelay: 10 * time.Second})
+
+	if got := r.backoff(0, 3*time.Second); got != 3*time.Second {
+		t.Errorf("Retry-After 3s: got %v", got)
+	}
+	if got := r.backoff(3, time.Second); got != time.Second {
+		t.Errorf("Retry-After must take precedence over the exponential delay: got %v", got)
+	}
+	if got := r.backoff(0, time.Hour); got != 10*time.Second {
+		t.Errorf("Retry-After must be capped at max delay: got %v", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"0", 0},
+		{"-3", 0},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.in, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCompleteHonorsRetryAfter(t *testing.T) {
+	calls := 0
+	fake := &Fake{Respond: func(Request) (string, error) {
+		calls++
+		if calls == 1 {
+			return "", &APIError{Kind: KindRateLimit, StatusCode: http.StatusTooManyRequests, RetryAfter: 20 * time.Millisecond}
+		}
+		return "ok", nil
+	}}
+	// 指数バックオフなら1時間待つため、Retry-After が使われなければ期限切れになる
+	r := NewResilient(fake, &config.AIConfig{MaxRetries: 2, RetryBaseDelay: time.Hour, RetryMaxDelay: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	out, err := r.Complete(ctx, Request{})
+	if err != nil || out != "ok" {
+		t.Fatalf("Complete = %q, %v", out, err)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2", calls)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("retried after %v, want at least the Retry-After of 20ms", elapsed)
+	}
+}
+
+func TestCompleteDoesNotRetryNonRetryable(t *testing.T) {
+	calls := 0
+	fake := &Fake{Respond: func(Request) (string, error) {
+		calls++
+		return "", &APIError{Kind: KindAuth, StatusCode: http.StatusUnauthorized}
+	}}
+	r := NewResilient(fake, &config.AIConfig{MaxRetries: 3, RetryBaseDelay: time.Millisecond})
+
+	if _, err := r.Complete(context.Background(), Request{}); !errors.Is(err, ErrAuth) {
+		t.Fatalf("err = %v, want ErrAuth", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	fail := true
+	calls := 0
+	fake := &Fake{Respond: func(Request) (string, error) {
+		calls++
+		if fail {
+			return "", transient()
+		}
+		return "ok", nil
+	}}
+	const cooldown = 50 * time.Millisecond
+	r := NewResilient(fake, &config.AIConfig{MaxRetries: -1, BreakerThreshold: 2, BreakerCooldown: cooldown})
+	ctx := context.Background()
+
+	// closed: しきい値未満の失敗は degraded
+	if _, err := r.Complete(ctx, Request{}); !errors.Is(err, ErrTransient) {
+		t.Fatalf("1st call: err = %v", err)
+	}
+	if st := status.GetAIStatus(); st.State != status.AIStateDegraded || st.ConsecutiveFailures != 1 {
+		t.Fatalf("after 1 failure: state = %v, failures = %d", st.State, st.ConsecutiveFailures)
+	}
+
+	// closed → open
+	r.Complete(ctx, Request{})
+	if st := status.GetAIStatus(); st.State != status.AIStateOpen {
+		t.Fatalf("after threshold: state = %v, want open", st.State)
+	}
+	if _, err := r.Complete(ctx, Request{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("while open: err = %v, want ErrCircuitOpen", err)
+	}
+	if calls != 2 {
+		t.Fatalf("calls while open = %d, want 2", calls)
+	}
+
+	// open → half-open: 試行が失敗したら再び open
+	time.Sleep(cooldown + 10*time.Millisecond)
+	if _, err := r.Complete(ctx, Request{}); !errors.Is(err, ErrTransient) {
+		t.Fatalf("half-open probe: err = %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("half-open must let one call through: calls = %d", calls)
+	}
+	if _, err := r.Complete(ctx, Request{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("after failed probe: err = %v, want ErrCircuitOpen", err)
+	}
+
+	// half-open → closed: 試行が成功したら閉じる
+	time.Sleep(cooldown + 10*time.Millisecond)
+	fail = false
+	if out, err := r.Complete(ctx, Request{}); err != nil || out != "ok" {
+		t.Fatalf("successful probe: %q, %v", out, err)
+	}
+	if st := status.GetAIStatus(); st.State != status.AIStateOK || st.ConsecutiveFailures != 0 {
+		t.Fatalf("after successful probe: state = %v, failures = %d", st.State, st.ConsecutiveFailures)
+	}
+}
+
+func TestHalfOpenProbeWhileOtherCallBlocked(t *testing.T) {
+	r := NewResilient(&Fake{Respond: func(Request) (string, error) { return "", transient() }},
+		&config.AIConfig{MaxRetries: -1, BreakerThreshold: 1, BreakerCooldown: time.Millisecond})
+	r.Complete(context.Background(), Request{})
+	time.Sleep(5 * time.Millisecond)
+
+	// 試行中は他の呼び出しを通さない
+	if err := r.allow(); err != nil {
+		t.Fatalf("probe must be allowed after cooldown: %v", err)
+	}
+	if err := r.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second call during probe: err = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestCallerCancelIsNotCounted(t *testing.T) {
+	r := NewResilient(&Fake{}, &config.AIConfig{MaxRetries: 3, BreakerThreshold: 1, RetryBaseDelay: time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.Complete(ctx, Request{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if r.failures != 0 {
+		t.Errorf("failures = %d, want 0", r.failures)
+	}
+	if st := status.GetAIStatus(); st.State != status.AIStateOK {
+		t.Errorf("state = %v, want ok", st.State)
+	}
+}
+
+func TestCallerDeadlineDuringBackoffIsNotCounted(t *testing.T) {
+	calls := 0
+	fake := &Fake{Respond: func(Request) (string, error) {
+		calls++
+		return "", transient()
+	}}
+	r := NewResilient(fake, &config.AIConfig{MaxRetries: 3, BreakerThreshold: 1, RetryBaseDelay: time.Hour, RetryMaxDelay: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := r.Complete(ctx, Request{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+	if r.failures != 0 || !r.openUntil.IsZero() {
+		t.Errorf("breaker changed by caller deadline: failures = %d, openUntil = %v", r.failures, r.openUntil)
+	}
+}
+
+func TestCanceledProbeKeepsHalfOpen(t *testing.T) {
+	fail := true
+	fake := &Fake{Respond: func(Request) (string, error) {
+		if fail {
+			return "", transient()
+		}
+		return "ok", nil
+	}}
+	r := NewResilient(fake, &config.AIConfig{MaxRetries: -1, BreakerThreshold: 1, BreakerCooldown: time.Millisecond})
+	r.Complete(context.Background(), Request{})
+	time.Sleep(5 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.Complete(ctx, Request{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+
+	// キャンセルされた試行は結果に数えず、次の呼び出しで改めて試行する
+	fail = false
+	if out, err := r.Complete(context.Background(), Request{}); err != nil || out != "ok" {
+		t.Fatalf("next probe: %q, %v", out, err)
+	}
+}
@@ -0,0 +1,206 @@
+package commands
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bot/config"
+	"bot/services"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+const (
+	archiveComponentPrefix = "archive"
+	archiveItemsPerPage    = 8
+	// archiveStateTTL を過ぎた検索結果はページ送りできなくなる
+	archiveStateTTL = 30 * time.Minute
+)
+
+var jst = time.FixedZone("JST", 9*3600)
+
+// カスタムIDは100文字までのため、検索条件はメモリに保持してIDだけをボタンに載せる
+type archiveState struct {
+	query   services.SearchQuery
+	created time.Time
+}
+
+var (
+	archiveMu     sync.Mutex
+	archiveStates = map[string]archiveState{}
+)
+
+func saveArchiveState(q services.SearchQuery) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	for k, st := range archiveStates {
+		if time.Since(st.created) > archiveStateTTL {
+			delete(archiveStates, k)
+		}
+	}
+	archiveStates[id] = archiveState{query: q, created: time.Now()}
+	return id
+}
+
+func loadArchiveState(id string) (services.SearchQuery, bool) {
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	st, ok := archiveStates[id]
+	if !ok || time.Since(st.created) > archiveStateTTL {
+		return services.SearchQuery{}, false
+	}
+	return st.query, true
+}
+
+// handleArchiveSearch は保存済み記事を検索し、1ページ目を返します。
+func handleArchiveSearch(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	options := commandOptions(i)
+	q := services.SearchQuery{
+		Keywords:  strings.TrimSpace(optionString(options, "query")),
+		Site:      optionString(options, "site"),
+		Category:  strings.TrimSpace(optionString(options, "category")),
+		StockCode: strings.TrimSpace(optionString(options, "code")),
+	}
+
+	var err error
+	if q.From, err = parseDate(optionString(options, "from")); err != nil {
+		respondEphemeral(s, i, logger, "⚠️ from は YYYY-MM-DD 形式で指定してください")
+		return
+	}
+	if q.To, err = parseDate(optionString(options, "to")); err != nil {
+		respondEphemeral(s, i, logger, "⚠️ to は YYYY-MM-DD 形式で指定してください")
+		return
+	}
+	if !q.To.IsZero() {
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+	if q.Keywords == "" && q.From.IsZero() && q.To.IsZero() && q.Site == "" && q.Category == "" && q.StockCode == "" {
+		respondEphemeral(s, i, logger, "⚠️ キーワードまたは条件を1つ以上指定してください")
+		return
+	}
+
+	res, err := deps.Archive.Search(ctx, q, 1, archiveItemsPerPage)
+	if err != nil {
+		logger.Error("記事検索に失敗しました", zap.Error(err))
+		respondError(s, i, logger, false, "記事の検索に失敗しました")
+		return
+	}
+	if res.Total == 0 {
+		respondEphemeral(s, i, logger, "🔍 条件に一致する記事は見つかりませんでした")
+		return
+	}
+
+	id := saveArchiveState(q)
+	respondData(s, i, logger, &discordgo.InteractionResponseData{
+		Embeds:     []*discordgo.MessageEmbed{buildArchiveEmbed(q, res)},
+		Components: pageButtons(archiveComponentPrefix+":"+id, res.Page, res.Pages),
+	})
+}
+
+// handleArchivePage はカスタムID "archive:<id>:<page>" のページ送りを処理します。
+func handleArchivePage(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	parts := strings.Split(i.MessageComponentData().CustomID, ":")
+	if len(parts) != 3 {
+		return
+	}
+	page, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return
+	}
+
+	q, ok := loadArchiveState(parts[1])
+	if !ok {
+		respondError(s, i, logger, false, "検索結果の有効期限が切れました。もう一度検索してください")
+		return
+	}
+
+	res, err := deps.Archive.Search(ctx, q, page, archiveItemsPerPage)
+	if err != nil {
+		logger.Error("記事検索に失敗しました", zap.Error(err))
+		respondError(s, i, logger, false, "記事の検索に失敗しました")
+		return
+	}
+
+	embeds := []*discordgo.MessageEmbed{buildArchiveEmbed(q, res)}
+	components := pageButtons(archiveComponentPrefix+":"+parts[1], res.Page, res.Pages)
+	if components == nil {
+		components = []discordgo.MessageComponent{}
+	}
+	editResponse(s, i, logger, &discordgo.WebhookEdit{Embeds: &embeds, Components: &components})
+}
+
+func buildArchiveEmbed(q services.SearchQuery, res *services.SearchResult) *discordgo.MessageEmbed {
+	fields := make([]*discordgo.MessageEmbedField, 0, len(res.Articles))
+	for _, a := range res.Articles {
+		name := a.PublishedAt.In(jst).Format("2006/01/02 15:04")
+		if a.Site != "" {
+			name += " [" + a.Site + "]"
+		}
+		if a.Category != "" {
+			name += " " + a.Category
+		}
+		value := fmt.Sprintf("[%s](%s)", truncate(a.Title, 80), a.URL)
+		if a.Summary != "" {
+			value += "\n" + truncate(strings.ReplaceAll(a.Summary, "\n", " "), 120)
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{Name: truncate(name, 250), Value: value})
+	}
+
+	return &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{
+			Name: "🔍 記事検索",
+		},
+		Description: fmt.Sprintf("%s\n%d件 (Page %d/%d)", describeQuery(q), res.Total, res.Page, res.Pages),
+		Color:       0x00BFFF,
+		Fields:      fields,
+		Timestamp:   time.Now().Format(time.RFC3339),
+		Footer:      &discordgo.MessageEmbedFooter{Text: "Bot " + config.Version},
+	}
+}
+
+func describeQuery(q services.SearchQuery) string {
+	var conds []string
+	if q.Keywords != "" {
+		conds = append(conds, fmt.Sprintf("キーワード: `%s`", q.Keywords))
+	}
+	if !q.From.IsZero() || !q.To.IsZero() {
+		from, to := "", ""
+		if !q.From.IsZero() {
+			from = q.From.In(jst).Format("2006-01-02")
+		}
+		if !q.To.IsZero() {
+			to = q.To.AddDate(0, 0, -1).In(jst).Format("2006-01-02")
+		}
+		conds = append(conds, fmt.Sprintf("期間: %s ～ %s", from, to))
+	}
+	if q.Site != "" {
+		conds = append(conds, "サイト: "+q.Site)
+	}
+	if q.Category != "" {
+		conds = append(conds, "カテゴリ: "+q.Category)
+	}
+	if q.StockCode != "" {
+		conds = append(conds, "銘柄コード: "+q.StockCode)
+	}
+	return strings.Join(conds, " / ")
+}
+
+// parseDate は YYYY-MM-DD を日本時間の0時として解釈します。空文字はゼロ値を返します。
+func parseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, jst)
+}
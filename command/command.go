@@ -1,189 +1,324 @@
 package commands
 
 import (
-   "fmt"
-		"time"
-    "github.com/bwmarrin/discordgo"
-    "go.uber.org/zap"
-		
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"bot/config"
+	"bot/models"
+	"bot/status"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
 )
-const version = "v1.2.0"
+
+// adminPermissions は Bot 全体の設定を変えるコマンドに必要な権限です。
+var adminPermissions int64 = discordgo.PermissionManageServer
+
+// guildOnly は DM での実行を禁止します。DM ではサーバーの権限を確認できないため、管理コマンドに付ける
+var guildOnly = false
+
+// definitions は Discord に登録するスラッシュコマンドの一覧です。
+// 管理コマンドは DefaultMemberPermissions で既定の実行者を絞り、routes の admin でも実行時に確認します。
+var definitions = []*discordgo.ApplicationCommand{
+	{
+		Name:         "scrape",
+		Description:  "スクレイプ操作を管理します",
+		DMPermission: &guildOnly,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "now",
+				Description: "即時に全サイトをスクレイプ実行",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "status",
+				Description: "スクレイプのステータスを表示",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "toggle",
+				Description: "指定サイトのスクレイプをON/OFF",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "site",
+						Description: "kabutan, ir, warning, tansaku, traders のいずれか",
+						Required:    true,
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:                     "set",
+		Description:              "フィルタや設定をリアルタイム変更",
+		DefaultMemberPermissions: &adminPermissions,
+		DMPermission:             &guildOnly,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "filter",
+				Description: "サイトのフィルタパラメータを更新",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "site", Description: "kabutan, ir, warning, tansaku, traders", Required: true},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "param", Description: "新しいフィルタ文字列 (- で設定ファイルの値に戻す)", Required: true},
+				},
+			},
+		},
+	},
+	{
+		Name:        "summary",
+		Description: "指定記事のAI要約を生成して返す",
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionString, Name: "url", Description: "要約対象のURL", Required: true},
+		},
+	},
+	{
+		Name:        "health",
+		Description: "Botのヘルスチェック情報を表示",
+	},
+	{
+		Name:                     "config",
+		Description:              "現在のBot設定を表示",
+		DefaultMemberPermissions: &adminPermissions,
+		DMPermission:             &guildOnly,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "show",
+				Description: "全設定値を一覧表示",
+			},
+		},
+	},
+	{
+		Name:                     "logs",
+		Description:              "ログレベルを変更します",
+		DefaultMemberPermissions: &adminPermissions,
+		DMPermission:             &guildOnly,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "level",
+				Description: "debug, info, warn, error のいずれか",
+				Required:    true,
+			},
+		},
+	},
+	{
+		Name:        "subscribe",
+		Description: "キーワード・銘柄コードの新着通知",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "購読を登録",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "type",
+						Description: "条件の種類",
+						Required:    true,
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "キーワード", Value: models.SubscriptionKeyword},
+							{Name: "正規表現", Value: models.SubscriptionRegex},
+							{Name: "銘柄コード", Value: models.SubscriptionCode},
+						},
+					},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "pattern", Description: "キーワード・正規表現・銘柄コード", Required: true},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "delivery",
+						Description: "通知方法（省略時: DM）",
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "DM", Value: models.DeliveryDM},
+							{Name: "チャンネルでメンション", Value: models.DeliveryChannel},
+						},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "購読を解除",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionInteger, Name: "id", Description: "/subscribe list で表示されるID", Required: true},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "登録中の購読を表示",
+			},
+		},
+	},
+	{
+		Name:        "watch",
+		Description: "ウォッチリストの銘柄を管理",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "銘柄をウォッチリストに追加",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "code", Description: "銘柄コード (例: 7203)", Required: true},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "digest",
+						Description: "DMダイジェストの頻度（全銘柄共通、省略時は変更しない）",
+						Choices:     watchDigestChoices,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "銘柄をウォッチリストから削除",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "code", Description: "銘柄コード", Required: true},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "ウォッチリストを表示",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "digest",
+						Description: "DMダイジェストの頻度を変更",
+						Choices:     watchDigestChoices,
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        "archive",
+		Description: "取得済記事を検索",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "search",
+				Description: "キーワードや条件で記事を検索",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "query", Description: "検索キーワード（空白区切りでAND検索）"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "from", Description: "開始日 (YYYY-MM-DD)"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "to", Description: "終了日 (YYYY-MM-DD、当日を含む)"},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "site",
+						Description: "取得元サイト",
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "kabutan", Value: "kabutan"},
+							{Name: "ir", Value: "ir"},
+							{Name: "warning", Value: "warning"},
+							{Name: "tansaku", Value: "tansaku"},
+							{Name: "traders", Value: "traders"},
+						},
+					},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "category", Description: "カテゴリ（部分一致）"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "code", Description: "銘柄コード (例: 7203)"},
+				},
+			},
+		},
+	},
+	{
+		Name:        "version",
+		Description: "Botのバージョンとデプロイ日時を表示",
+	},
+	{
+		Name:        "help",
+		Description: "利用可能なコマンド一覧を表示",
+	},
+}
+
 // RegisterAll registers all slash commands for the bot
 func RegisterAll(s *discordgo.Session, logger *zap.Logger) error {
-    commands := []*discordgo.ApplicationCommand{
-        {
-            Name:        "scrape",
-            Description: "スクレイプ操作を管理します",
-            Options: []*discordgo.ApplicationCommandOption{
-                {
-                    Type:        discordgo.ApplicationCommandOptionSubCommand,
-                    Name:        "now",
-                    Description: "即時に全サイトをスクレイプ実行",
-                },
-                {
-                    Type:        discordgo.ApplicationCommandOptionSubCommand,
-                    Name:        "status",
-                    Description: "スクレイプのステータスを表示",
-                },
-                {
-                    Type:        discordgo.ApplicationCommandOptionSubCommand,
-                    Name:        "toggle",
-                    Description: "指定サイトのスクレイプをON/OFF",
-                    Options: []*discordgo.ApplicationCommandOption{
-                        {
-                            Type:        discordgo.ApplicationCommandOptionString,
-                            Name:        "site",
-                            Description: "kabutan, traders, ir のいずれか",
-                            Required:    true,
-                        },
-                    },
-                },
-            },
-        },
-        {
-            Name:        "set",
-            Description: "フィルタや設定をリアルタイム変更",
-            Options: []*discordgo.ApplicationCommandOption{
-                {
-                    Type:        discordgo.ApplicationCommandOptionSubCommand,
-                    Name:        "filter",
-                    Description: "サイトのフィルタパラメータを更新",
-                    Options: []*discordgo.ApplicationCommandOption{
-                        {Type: discordgo.ApplicationCommandOptionString, Name: "site", Description: "kabutan, traders, ir", Required: true},
-                        {Type: discordgo.ApplicationCommandOptionString, Name: "param", Description: "新しいフィルタ文字列", Required: true},
-                    },
-                },
-            },
-        },
-        {
-            Name:        "summary",
-            Description: "指定記事のAI要約を生成して返す",
-            Options: []*discordgo.ApplicationCommandOption{
-                {Type: discordgo.ApplicationCommandOptionString, Name: "url", Description: "要約対象のURL", Required: true},
-            },
-        },
-        {
-            Name:        "health",
-            Description: "Botのヘルスチェック情報を表示",
-        },
-        {
-            Name:        "config",
-            Description: "現在のBot設定を表示",
-            Options: []*discordgo.ApplicationCommandOption{
-                {
-                    Type:        discordgo.ApplicationCommandOptionSubCommand,
-                    Name:        "show",
-                    Description: "全設定値を一覧表示",
-                },
-            },
-        },
-        {
-            Name:        "logs",
-            Description: "ログレベルを変更します",
-            Options: []*discordgo.ApplicationCommandOption{
-                {
-                    Type:        discordgo.ApplicationCommandOptionString,
-                    Name:        "level",
-                    Description: "debug, info, warn, error のいずれか",
-                    Required:    true,
-                },
-            },
-        },
-        {
-            Name:        "subscribe",
-            Description: "キーワード通知を登録/解除",
-            Options: []*discordgo.ApplicationCommandOption{
-                {Type: discordgo.ApplicationCommandOptionString, Name: "keyword", Description: "通知するキーワード", Required: true},
-                {Type: discordgo.ApplicationCommandOptionBoolean, Name: "enable", Description: "登録(true)か解除(false)", Required: true},
-            },
-        },
-        {
-            Name:        "archive",
-            Description: "取得済記事を検索",
-            Options: []*discordgo.ApplicationCommandOption{
-                {
-                    Type:        discordgo.ApplicationCommandOptionSubCommand,
-                    Name:        "search",
-                    Description: "キーワードで記事を検索",
-                    Options: []*discordgo.ApplicationCommandOption{
-                        {Type: discordgo.ApplicationCommandOptionString, Name: "query", Description: "検索キーワード", Required: true},
-                    },
-                },
-            },
-        },
-        {
-            Name:        "version",
-            Description: "Botのバージョンとデプロイ日時を表示",
-        },
-        {
-            Name:        "help",
-            Description: "利用可能なコマンド一覧を表示",
-        },
-    }
-
-    for _, cmd := range commands {
-        if _, err := s.ApplicationCommandCreate(s.State.User.ID, "", cmd); err != nil {
-            logger.Error("スラッシュコマンド登録失敗", zap.Error(err), zap.String("command", cmd.Name))
-            return err
-        }
-    }
-    return nil
-}
-func respond(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger, message string) {
-	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-					Content: message,
-			},
-	})
+	// 一括上書きで登録することで、削除済みコマンドも同時に反映される
+	created, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, "", definitions)
 	if err != nil {
-			logger.Error("インタラクション応答に失敗", zap.Error(err))
+		logger.Error("スラッシュコマンド登録失敗", zap.Error(err))
+		return err
 	}
-}
-// HandleInteraction routes slash commands to their handlers
-func HandleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
-    switch i.ApplicationCommandData().Name {
-    case "scrape":
-        handleScrape(s, i, logger)
-    case "set":
-        handleSet(s, i, logger)
-    case "summary":
-        handleSummary(s, i, logger)
-    case "health":
-        handleHealth(s, i, logger)
-    case "config":
-        handleConfig(s, i, logger)
-    case "logs":
-        handleLogs(s, i, logger)
-    case "subscribe":
-        handleSubscribe(s, i, logger)
-    case "archive":
-        handleArchive(s, i, logger)
-    case "version":
-        handleVersion(s, i, logger)
-    case "help":
-        handleHelp(s, i, logger)
-    default:
-        logger.Warn("不明なコマンド", zap.String("name", i.ApplicationCommandData().Name))
-    }
+	logger.Info("スラッシュコマンドを登録しました", zap.Int("件数", len(created)))
+	return nil
 }
 
-// 下記に各コマンドのハンドラーを実装してください
-func handleScrape(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {}
-func handleSet(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {}
-func handleSummary(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {}
-func handleHealth(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+func handleHealth(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
 	now := time.Now().Format("2006-01-02 15:04:05")
 	message := fmt.Sprintf("🟢 Bot稼働中\n現在時刻: %s", now)
+
+	ai := status.GetAIStatus()
+	switch ai.State {
+	case status.AIStateOK:
+		message += fmt.Sprintf("\n🟢 AI (%s): 正常", ai.Provider)
+	case status.AIStateDegraded:
+		message += fmt.Sprintf("\n🟡 AI (%s): 連続失敗 %d 回\n直近エラー: %s", ai.Provider, ai.ConsecutiveFailures, truncate(ai.LastError, 200))
+	case status.AIStateOpen:
+		message += fmt.Sprintf("\n🔴 AI (%s): 一時停止中 (%s まで)\n直近エラー: %s", ai.Provider, discordTime(ai.OpenUntil, "T"), truncate(ai.LastError, 200))
+	}
 	respond(s, i, logger, message)
 }
 
-func handleConfig(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {}
-func handleLogs(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {}
-func handleSubscribe(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {}
-func handleArchive(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {}
-func handleVersion(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
-	message := fmt.Sprintf("🤖 Bot バージョン: %s", version)
+func handleConfig(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	keys := viper.AllKeys()
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		value := fmt.Sprintf("%v", viper.Get(key))
+		if config.IsSecretKey(key) {
+			value = config.Redact(value)
+		}
+		fmt.Fprintf(&b, "%s = %s\n", key, value)
+	}
+
+	respondEphemeral(s, i, logger, "```ini\n"+truncate(b.String(), 1900)+"\n```")
+}
+
+func handleLogs(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	level := optionString(commandOptions(i), "level")
+	if err := config.SetLogLevel(level); err != nil {
+		respondEphemeral(s, i, logger, fmt.Sprintf("⚠️ %v", err))
+		return
+	}
+	logger.Info("ログレベルを変更しました",
+		zap.String("level", level),
+		zap.String("user", interactionUser(i)))
+	respond(s, i, logger, fmt.Sprintf("📝 ログレベルを `%s` に変更しました", level))
+}
+
+func handleVersion(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	message := fmt.Sprintf("🤖 Bot バージョン: %s", config.Version)
 	respond(s, i, logger, message)
 }
-func handleHelp(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {}
+
+func handleHelp(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	fields := make([]*discordgo.MessageEmbedField, 0, len(definitions))
+	for _, cmd := range definitions {
+		var subs []string
+		for _, opt := range cmd.Options {
+			if opt.Type == discordgo.ApplicationCommandOptionSubCommand {
+				subs = append(subs, fmt.Sprintf("`/%s %s` %s", cmd.Name, opt.Name, opt.Description))
+			}
+		}
+		value := cmd.Description
+		if len(subs) > 0 {
+			value = strings.Join(subs, "\n")
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "/" + cmd.Name, Value: value})
+	}
+
+	respondEmbed(s, i, logger, &discordgo.MessageEmbed{
+		Title:  "📖 コマンド一覧",
+		Color:  0x0099FF,
+		Fields: fields,
+		Footer: &discordgo.MessageEmbedFooter{Text: "Bot " + config.Version},
+	})
+}
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"sync/atomic"
+
+	"bot/services"
+)
+
+// Dependencies はコマンドハンドラーが利用するサービス群です。
+type Dependencies struct {
+	Runner        *services.SiteRunner
+	Settings      *services.SettingsStore
+	Scheduler     *services.Scheduler
+	Summary       *services.SummaryService
+	Archive       *services.ArchiveService
+	Subscriptions *services.SubscriptionService
+	Watchlist     *services.WatchlistService
+}
+
+var deps Dependencies
+
+// ready は Setup が deps を設定し終えたかどうかです。
+// Discord の接続後に Setup するため、それまでに届いたインタラクションは ready を見て断る。
+var ready atomic.Bool
+
+// Setup はコマンドハンドラーの依存を設定します。一度だけ呼び出してください。
+// 呼び出すまでに届いたコマンドには、起動中である旨を返します。
+func Setup(d Dependencies) {
+	deps = d
+	ready.Store(true)
+}
@@ -0,0 +1,395 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// handlerFunc は各コマンドの処理本体です。
+// ctx はルートごとのタイムアウトでキャンセルされます。
+type handlerFunc func(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger)
+
+// route はコマンド（またはサブコマンド）ごとのハンドラー設定です。
+type route struct {
+	handler handlerFunc
+	// deferred が true の場合、ルーターが先に「考え中」応答を返す。
+	// ハンドラーは editResponse で結果を書き込むこと。
+	deferred bool
+	timeout  time.Duration
+	// admin が true の場合、サーバーの管理権限を持つメンバーのみ実行できる。
+	// サブコマンドごとには DefaultMemberPermissions を設定できないため、実行時にも確認する
+	admin bool
+}
+
+const (
+	defaultTimeout  = 10 * time.Second
+	deferredTimeout = 3 * time.Minute
+)
+
+// inflight は実行中のハンドラー数です。シャットダウン時に Wait で応答の完了を待ちます。
+var inflight sync.WaitGroup
+
+// timedOut はタイムアウトのエラーを返したインタラクションのIDです。
+// ハンドラーはタイムアウト後も動き続けるため、その後の応答は送らずに捨てる。
+var timedOut sync.Map
+
+// expired はインタラクションが既にタイムアウトしているかどうかを返します。
+func expired(i *discordgo.InteractionCreate) bool {
+	_, ok := timedOut.Load(i.ID)
+	return ok
+}
+
+// routes のキーは "コマンド名" または "コマンド名 サブコマンド名"
+var routes = map[string]route{
+	"scrape now":       {handler: handleScrapeNow, deferred: true},
+	"scrape status":    {handler: handleScrapeStatus},
+	"scrape toggle":    {handler: handleScrapeToggle, admin: true},
+	"set filter":       {handler: handleSetFilter, admin: true},
+	"summary":          {handler: handleSummary, deferred: true},
+	"health":           {handler: handleHealth},
+	"config show":      {handler: handleConfig, admin: true},
+	"logs":             {handler: handleLogs, admin: true},
+	"subscribe add":    {handler: handleSubscribeAdd},
+	"subscribe remove": {handler: handleSubscribeRemove},
+	"subscribe list":   {handler: handleSubscribeList},
+	"watch add":        {handler: handleWatchAdd},
+	"watch remove":     {handler: handleWatchRemove},
+	"watch list":       {handler: handleWatchList},
+	"archive search":   {handler: handleArchiveSearch},
+	"version":          {handler: handleVersion},
+	"help":             {handler: handleHelp},
+}
+
+// componentRoutes のキーはボタン等のカスタムIDの最初の ":" より前の部分。
+// ルーターが先に DeferredMessageUpdate を返すため、ハンドラーは editResponse で元のメッセージを書き換えること。
+var componentRoutes = map[string]handlerFunc{
+	archiveComponentPrefix: handleArchivePage,
+}
+
+// routeKey はインタラクションからルーティング用のキーを組み立てます。
+func routeKey(data discordgo.ApplicationCommandInteractionData) string {
+	key := data.Name
+	options := data.Options
+	for len(options) > 0 {
+		opt := options[0]
+		if opt.Type != discordgo.ApplicationCommandOptionSubCommandGroup &&
+			opt.Type != discordgo.ApplicationCommandOptionSubCommand {
+			break
+		}
+		key += " " + opt.Name
+		options = opt.Options
+	}
+	return key
+}
+
+// commandOptions はサブコマンドを辿った末端のオプション一覧を返します。
+func commandOptions(i *discordgo.InteractionCreate) []*discordgo.ApplicationCommandInteractionDataOption {
+	options := i.ApplicationCommandData().Options
+	for len(options) > 0 {
+		opt := options[0]
+		if opt.Type != discordgo.ApplicationCommandOptionSubCommandGroup &&
+			opt.Type != discordgo.ApplicationCommandOptionSubCommand {
+			break
+		}
+		options = opt.Options
+	}
+	return options
+}
+
+// HandleInteraction routes slash commands and message components to their handlers
+func HandleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		handleCommand(s, i, logger)
+	case discordgo.InteractionMessageComponent:
+		handleComponent(s, i, logger)
+	}
+}
+
+func handleCommand(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	key := routeKey(i.ApplicationCommandData())
+	r, ok := routes[key]
+	if !ok {
+		logger.Warn("不明なコマンド", zap.String("name", key))
+		respondError(s, i, logger, false, "不明なコマンドです")
+		return
+	}
+	if !ready.Load() {
+		respondError(s, i, logger, false, "起動中です。しばらくしてから再度お試しください")
+		return
+	}
+	if r.admin && !isAdmin(i) {
+		logger.Warn("権限のないユーザーが管理コマンドを実行しようとしました",
+			zap.String("command", key),
+			zap.String("user", interactionUser(i)))
+		respondError(s, i, logger, false, "このコマンドにはサーバー管理権限が必要です")
+		return
+	}
+
+	if r.deferred {
+		if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		}); err != nil {
+			logger.Error("Deferred 応答エラー", zap.String("command", key), zap.Error(err))
+			return
+		}
+	}
+	run(s, i, logger, key, r)
+}
+
+// handleComponent は componentRoutes に登録されたボタンのみを処理します。
+// 未登録のカスタムID（hourly_prev: など）は他のハンドラーに任せます。
+func handleComponent(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	customID := i.MessageComponentData().CustomID
+	prefix, _, _ := strings.Cut(customID, ":")
+	handler, ok := componentRoutes[prefix]
+	if !ok {
+		return
+	}
+	if !ready.Load() {
+		respondError(s, i, logger, false, "起動中です。しばらくしてから再度お試しください")
+		return
+	}
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	}); err != nil {
+		logger.Error("Deferred 応答エラー", zap.String("custom_id", customID), zap.Error(err))
+		return
+	}
+	// エラー時に元のメッセージを消さないよう、deferred 扱いにせずフォローアップで返す
+	run(s, i, logger, customID, route{handler: handler})
+}
+
+// run はタイムアウトとパニック回復付きでハンドラーを実行します。
+func run(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger, key string, r route) {
+	timeout := r.timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+		if r.deferred {
+			timeout = deferredTimeout
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// スタックはパニックしたゴルーチンの中で取得しないと、発生箇所が含まれない
+	done := make(chan *handlerPanic, 1)
+	inflight.Add(1)
+	go func() {
+		defer inflight.Done()
+		defer func() {
+			if rec := recover(); rec != nil {
+				done <- &handlerPanic{value: rec, stack: debug.Stack()}
+				return
+			}
+			done <- nil
+		}()
+		r.handler(ctx, s, i, logger)
+	}()
+
+	select {
+	case p := <-done:
+		if p != nil {
+			logger.Error("コマンド処理中にパニックが発生しました",
+				zap.String("command", key),
+				zap.Any("panic", p.value),
+				zap.ByteString("stack", p.stack))
+			respondError(s, i, logger, r.deferred, "内部エラーが発生しました")
+		}
+	case <-ctx.Done():
+		logger.Warn("コマンド処理がタイムアウトしました",
+			zap.String("command", key),
+			zap.Duration("timeout", timeout))
+		// 以降にハンドラーが返す応答は捨てる。ハンドラーの終了後に記録を消す
+		timedOut.Store(i.ID, struct{}{})
+		go func() {
+			<-done
+			timedOut.Delete(i.ID)
+		}()
+		sendError(s, i, logger, r.deferred, "処理がタイムアウトしました。しばらくしてから再度お試しください")
+	}
+}
+
+// handlerPanic はハンドラーのパニックの値と、発生時のスタックです。
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// Wait は実行中のコマンドがすべて終わるか ctx が終了するまで待ちます。
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func respond(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger, message string) {
+	respondData(s, i, logger, &discordgo.InteractionResponseData{Content: message})
+}
+
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger, message string) {
+	respondData(s, i, logger, &discordgo.InteractionResponseData{
+		Content: message,
+		Flags:   discordgo.MessageFlagsEphemeral,
+	})
+}
+
+func respondEmbed(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger, embed *discordgo.MessageEmbed) {
+	respondData(s, i, logger, &discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{embed}})
+}
+
+// respondData はメッセージで応答します。タイムアウト後の応答は送りません。
+func respondData(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger, data *discordgo.InteractionResponseData) {
+	if expired(i) {
+		logger.Debug("タイムアウト後の応答を破棄しました", zap.String("interaction_id", i.ID))
+		return
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: data,
+	})
+	if err != nil {
+		logger.Error("インタラクション応答に失敗", zap.Error(err))
+	}
+}
+
+// editResponse は deferred ルートの応答を書き換えます。タイムアウト後の応答は送りません。
+func editResponse(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger, edit *discordgo.WebhookEdit) {
+	if expired(i) {
+		logger.Debug("タイムアウト後の応答を破棄しました", zap.String("interaction_id", i.ID))
+		return
+	}
+	if _, err := s.InteractionResponseEdit(i.Interaction, edit); err != nil {
+		logger.Error("インタラクション応答の編集に失敗", zap.Error(err))
+	}
+}
+
+// respondError はエラーをコマンド実行者のみに見える形で返します。タイムアウト後の応答は送りません。
+func respondError(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger, deferred bool, message string) {
+	if expired(i) {
+		logger.Debug("タイムアウト後の応答を破棄しました", zap.String("interaction_id", i.ID))
+		return
+	}
+	sendError(s, i, logger, deferred, message)
+}
+
+func sendError(s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger, deferred bool, message string) {
+	content := "⚠️ " + message
+	if !deferred {
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err == nil {
+			return
+		}
+		// ハンドラーが既に応答済みの場合はフォローアップで返す
+	} else if err := s.InteractionResponseDelete(i.Interaction); err != nil {
+		logger.Debug("Deferred 応答の削除に失敗", zap.Error(err))
+	}
+
+	if _, err := s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: content,
+		Flags:   discordgo.MessageFlagsEphemeral,
+	}); err != nil {
+		logger.Error("エラー応答の送信に失敗", zap.Error(err))
+	}
+}
+
+func optionString(options []*discordgo.ApplicationCommandInteractionDataOption, name string) string {
+	for _, opt := range options {
+		if opt.Name == name && opt.Type == discordgo.ApplicationCommandOptionString {
+			return opt.StringValue()
+		}
+	}
+	return ""
+}
+
+func optionInt(options []*discordgo.ApplicationCommandInteractionDataOption, name string) int64 {
+	for _, opt := range options {
+		if opt.Name == name && opt.Type == discordgo.ApplicationCommandOptionInteger {
+			return opt.IntValue()
+		}
+	}
+	return 0
+}
+
+// isAdmin はコマンド実行者がサーバーの管理権限を持つかどうかを返します。DM では常に false です。
+func isAdmin(i *discordgo.InteractionCreate) bool {
+	if i.Member == nil {
+		return false
+	}
+	return i.Member.Permissions&(discordgo.PermissionManageServer|discordgo.PermissionAdministrator) != 0
+}
+
+// interactionUserID はコマンド実行者のユーザーIDを返します。
+func interactionUserID(i *discordgo.InteractionCreate) string {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return ""
+}
+
+func interactionUser(i *discordgo.InteractionCreate) string {
+	if i.Member != nil && i.Member.User != nil {
+		return fmt.Sprintf("%s (%s)", i.Member.User.Username, i.Member.User.ID)
+	}
+	if i.User != nil {
+		return fmt.Sprintf("%s (%s)", i.User.Username, i.User.ID)
+	}
+	return "unknown"
+}
+
+// pageButtons は buildHourlyEmbed と同じ形式の前後ページボタンを返します。
+// カスタムIDは "<prefix>:<ページ番号>"。1ページしかない場合は nil です。
+func pageButtons(prefix string, page, total int) []discordgo.MessageComponent {
+	row := discordgo.ActionsRow{}
+	if page > 1 {
+		row.Components = append(row.Components, discordgo.Button{
+			Label:    "◀️ Prev",
+			Style:    discordgo.PrimaryButton,
+			CustomID: fmt.Sprintf("%s:%d", prefix, page-1),
+		})
+	}
+	if page < total {
+		row.Components = append(row.Components, discordgo.Button{
+			Label:    "Next ▶️",
+			Style:    discordgo.PrimaryButton,
+			CustomID: fmt.Sprintf("%s:%d", prefix, page+1),
+		})
+	}
+	if len(row.Components) == 0 {
+		return nil
+	}
+	return []discordgo.MessageComponent{row}
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max]) + "…"
+}
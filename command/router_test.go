@@ -0,0 +1,132 @@
+package commands
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// definitionKeys は Discord に登録するコマンドから routes のキーを組み立てます。
+func definitionKeys() []string {
+	var keys []string
+	var walk func(prefix string, options []*discordgo.ApplicationCommandOption) bool
+	walk = func(prefix string, options []*discordgo.ApplicationCommandOption) bool {
+		found := false
+		for _, opt := range options {
+			if opt.Type != discordgo.ApplicationCommandOptionSubCommandGroup &&
+				opt.Type != discordgo.ApplicationCommandOptionSubCommand {
+				continue
+			}
+			found = true
+			key := prefix + " " + opt.Name
+			if !walk(key, opt.Options) {
+				keys = append(keys, key)
+			}
+		}
+		return found
+	}
+	for _, cmd := range definitions {
+		if !walk(cmd.Name, cmd.Options) {
+			keys = append(keys, cmd.Name)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TestRoutesCoverDefinitions は登録したコマンドやサブコマンドにハンドラーがない場合に失敗します。
+func TestRoutesCoverDefinitions(t *testing.T) {
+	keys := definitionKeys()
+	if len(keys) == 0 {
+		t.Fatal("no command definitions")
+	}
+	defined := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		defined[key] = true
+		r, ok := routes[key]
+		if !ok {
+			t.Errorf("/%s is registered with Discord but has no entry in routes", key)
+			continue
+		}
+		if r.handler == nil {
+			t.Errorf("routes[%q] has no handler", key)
+		}
+	}
+	for key := range routes {
+		if !defined[key] {
+			t.Errorf("routes[%q] has no command definition and can never be called", key)
+		}
+	}
+}
+
+func TestRouteKey(t *testing.T) {
+	tests := []struct {
+		data discordgo.ApplicationCommandInteractionData
+		want string
+	}{
+		{discordgo.ApplicationCommandInteractionData{Name: "version"}, "version"},
+		{discordgo.ApplicationCommandInteractionData{
+			Name: "logs",
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "level", Value: "debug"},
+			},
+		}, "logs"},
+		{discordgo.ApplicationCommandInteractionData{
+			Name: "watch",
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "add", Options: []*discordgo.ApplicationCommandInteractionDataOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "code", Value: "7203"},
+				}},
+			},
+		}, "watch add"},
+	}
+	for _, tt := range tests {
+		if got := routeKey(tt.data); got != tt.want {
+			t.Errorf("routeKey(%s) = %q, want %q", tt.data.Name, got, tt.want)
+		}
+	}
+}
+
+// TestAdminRoutes は Bot 全体の設定を変えるコマンドに管理権限が必要なことを確認します。
+func TestAdminRoutes(t *testing.T) {
+	for _, key := range []string{"scrape toggle", "set filter", "config show", "logs"} {
+		if !routes[key].admin {
+			t.Errorf("routes[%q] must require admin", key)
+		}
+	}
+	for _, cmd := range definitions {
+		admin := true
+		for _, key := range definitionKeys() {
+			if (key == cmd.Name || strings.HasPrefix(key, cmd.Name+" ")) && !routes[key].admin {
+				admin = false
+			}
+		}
+		// すべてのサブコマンドが管理用のコマンドは、権限のないメンバーには表示しない
+		if admin && (cmd.DefaultMemberPermissions == nil || *cmd.DefaultMemberPermissions&discordgo.PermissionManageServer == 0) {
+			t.Errorf("/%s is admin-only but has no DefaultMemberPermissions", cmd.Name)
+		}
+		if admin && (cmd.DMPermission == nil || *cmd.DMPermission) {
+			t.Errorf("/%s is admin-only but can be used in DMs", cmd.Name)
+		}
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	tests := []struct {
+		name string
+		i    *discordgo.InteractionCreate
+		want bool
+	}{
+		{"manage server", &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{Member: &discordgo.Member{Permissions: discordgo.PermissionManageServer}}}, true},
+		{"administrator", &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{Member: &discordgo.Member{Permissions: discordgo.PermissionAdministrator}}}, true},
+		{"member", &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{Member: &discordgo.Member{Permissions: discordgo.PermissionSendMessages}}}, false},
+		{"dm", &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{User: &discordgo.User{ID: "1"}}}, false},
+	}
+	for _, tt := range tests {
+		if got := isAdmin(tt.i); got != tt.want {
+			t.Errorf("%s: isAdmin = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
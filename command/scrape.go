@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"bot/config"
+	"bot/market"
+	"bot/services"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// handleScrapeNow は登録済みの全サイトを即時にスクレイピングし、結果を返します。
+func handleScrapeNow(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	logger.Info("手動スクレイピングを開始します", zap.String("user", interactionUser(i)))
+
+	start := time.Now()
+	results := deps.Runner.RunAll(ctx)
+
+	total := 0
+	color := 0x00FF00
+	fields := make([]*discordgo.MessageEmbedField, 0, len(results))
+	for _, r := range results {
+		var value string
+		switch {
+		case errors.Is(r.Err, services.ErrAlreadyRunning):
+			value = "⏭ 実行中のためスキップ"
+		case errors.Is(r.Err, services.ErrDisabled):
+			value = "⏸ 無効化中"
+		case r.Err != nil:
+			value = fmt.Sprintf("❌ %s\n⏱ %s", truncate(r.Err.Error(), 200), r.Duration.Round(time.Millisecond))
+			color = 0xFF0000
+		default:
+			value = fmt.Sprintf("🆕 %d件\n⏱ %s", r.NewArticles, r.Duration.Round(time.Millisecond))
+			total += r.NewArticles
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{Name: r.Site, Value: value, Inline: true})
+	}
+
+	embeds := []*discordgo.MessageEmbed{{
+		Title:       "🔄 スクレイピング結果",
+		Description: fmt.Sprintf("新着 %d件 (合計 %s)", total, time.Since(start).Round(time.Millisecond)),
+		Color:       color,
+		Fields:      fields,
+		Timestamp:   time.Now().Format(time.RFC3339),
+		Footer:      &discordgo.MessageEmbedFooter{Text: "Bot " + config.Version},
+	}}
+	editResponse(s, i, logger, &discordgo.WebhookEdit{Embeds: &embeds})
+}
+
+// handleScrapeToggle は指定サイトの定期スクレイピングを有効/無効に切り替えます。
+func handleScrapeToggle(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	site := strings.ToLower(strings.TrimSpace(optionString(commandOptions(i), "site")))
+	if !deps.Runner.Has(site) {
+		respondEphemeral(s, i, logger, fmt.Sprintf("⚠️ 不明なサイトです: `%s` (%s のいずれか)", site, strings.Join(deps.Runner.Sites(), ", ")))
+		return
+	}
+
+	enabled, err := deps.Settings.Toggle(site)
+	if err != nil {
+		logger.Error("スクレイプ切り替えに失敗しました", zap.String("site", site), zap.Error(err))
+		respondEphemeral(s, i, logger, "⚠️ 設定の保存に失敗しました")
+		return
+	}
+
+	logger.Info("スクレイプを切り替えました",
+		zap.String("site", site),
+		zap.Bool("enabled", enabled),
+		zap.String("user", interactionUser(i)))
+	respond(s, i, logger, fmt.Sprintf("%s `%s` のスクレイプを%sにしました", enabledMark(enabled), site, enabledLabel(enabled)))
+}
+
+// handleScrapeStatus はジョブごとの実行状況とサイトの有効/無効を表示します。
+// 連続失敗が scraping.error_streak_threshold 以上のジョブは赤で表示します。
+func handleScrapeStatus(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	threshold := viper.GetInt("scraping.error_streak_threshold")
+	statuses := deps.Scheduler.Statuses()
+
+	color := 0x00BFFF
+	fields := make([]*discordgo.MessageEmbedField, 0, len(statuses))
+	for _, st := range statuses {
+		mark := "🟢"
+		switch {
+		case threshold > 0 && st.ConsecutiveFailures >= threshold:
+			mark = "🔴"
+			color = 0xFF0000
+		case st.ConsecutiveFailures > 0:
+			mark = "🟡"
+		case deps.Runner.Has(st.Name) && !deps.Settings.Enabled(st.Name):
+			mark = "⏸"
+		}
+
+		lines := []string{
+			fmt.Sprintf("前回開始: %s", discordTime(st.LastStart, "R")),
+			fmt.Sprintf("最終成功: %s", discordTime(st.LastSuccess, "R")),
+			fmt.Sprintf("所要時間: %s%s", st.LastDuration.Round(time.Millisecond), timeoutLabel(st.Timeout)),
+			fmt.Sprintf("連続失敗: %d", st.ConsecutiveFailures),
+			fmt.Sprintf("次回実行: %s", discordTime(st.NextRun, "T")),
+		}
+		if deps.Runner.Has(st.Name) {
+			lines = append(lines, "状態: "+enabledLabel(deps.Settings.Enabled(st.Name)))
+		}
+		if st.Window != market.WindowAlways {
+			window := "時間帯: " + st.Window.String()
+			if !st.InWindow {
+				window += " (時間外)"
+			}
+			lines = append(lines, window)
+		}
+		if st.SkippedOverlaps > 0 || st.Panics > 0 {
+			lines = append(lines, fmt.Sprintf("重複見送り: %d / パニック: %d", st.SkippedOverlaps, st.Panics))
+		}
+		if st.Running {
+			lines = append(lines, "⏳ 実行中")
+		}
+		if st.LastError != "" {
+			lines = append(lines, "直近エラー: "+truncate(st.LastError, 120))
+		}
+
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:   fmt.Sprintf("%s %s (`%s`)", mark, st.Name, st.Schedule),
+			Value:  strings.Join(lines, "\n"),
+			Inline: true,
+		})
+	}
+
+	respondEmbed(s, i, logger, &discordgo.MessageEmbed{
+		Title:       "📊 スクレイプステータス",
+		Description: fmt.Sprintf("🔴 は連続失敗 %d 回以上のジョブ", threshold),
+		Color:       color,
+		Fields:      fields,
+		Timestamp:   time.Now().Format(time.RFC3339),
+		Footer:      &discordgo.MessageEmbedFooter{Text: "Bot " + config.Version},
+	})
+}
+
+// timeoutLabel はタスクの期限を " (上限 5m0s)" の形式で返します。期限なしの場合は空文字列です。
+func timeoutLabel(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (上限 %s)", d)
+}
+
+// discordTime は Discord のタイムスタンプ記法に変換します。ゼロ値は "-" を返します。
+func discordTime(t time.Time, style string) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return fmt.Sprintf("<t:%d:%s>", t.Unix(), style)
+}
+
+func enabledMark(enabled bool) string {
+	if enabled {
+		return "🟢"
+	}
+	return "⏸"
+}
+
+func enabledLabel(enabled bool) string {
+	if enabled {
+		return "有効"
+	}
+	return "無効"
+}
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// handleSetFilter はサイトのフィルタを更新します。次回のスケジュール実行から反映されます。
+func handleSetFilter(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	options := commandOptions(i)
+	site := strings.ToLower(strings.TrimSpace(optionString(options, "site")))
+	param := optionString(options, "param")
+
+	if !deps.Runner.Has(site) {
+		respondEphemeral(s, i, logger, fmt.Sprintf("⚠️ 不明なサイトです: `%s` (%s のいずれか)", site, strings.Join(deps.Runner.Sites(), ", ")))
+		return
+	}
+
+	user := interactionUser(i)
+	var (
+		old string
+		err error
+	)
+	if param == "-" {
+		// 空のフィルタで固定せず、設定ファイルの値に戻す
+		old, err = deps.Settings.ResetFilter(site, user)
+	} else {
+		old, err = deps.Settings.SetFilter(site, param, user)
+	}
+	if err != nil {
+		respondEphemeral(s, i, logger, fmt.Sprintf("⚠️ %v", err))
+		return
+	}
+	current := deps.Settings.Filter(site)
+
+	if channelID := viper.GetString("discord.log_channel"); channelID != "" {
+		if _, err := s.ChannelMessageSendEmbed(channelID, &discordgo.MessageEmbed{
+			Title: "🔧 フィルタ変更",
+			Color: 0xFFA500,
+			Fields: []*discordgo.MessageEmbedField{
+				{Name: "サイト", Value: site, Inline: true},
+				{Name: "変更者", Value: user, Inline: true},
+				{Name: "変更前", Value: codeOrNone(old)},
+				{Name: "変更後", Value: codeOrNone(current)},
+			},
+		}); err != nil {
+			logger.Error("ログチャンネルへの送信に失敗", zap.Error(err))
+		}
+	}
+
+	respond(s, i, logger, fmt.Sprintf("🔧 `%s` のフィルタを %s に変更しました", site, codeOrNone(current)))
+}
+
+func codeOrNone(s string) string {
+	if s == "" {
+		return "(なし)"
+	}
+	return "`" + s + "`"
+}
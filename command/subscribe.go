@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"bot/models"
+	"bot/services"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+func handleSubscribeAdd(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	options := commandOptions(i)
+	delivery := optionString(options, "delivery")
+	if delivery == "" {
+		delivery = models.DeliveryDM
+	}
+	if delivery == models.DeliveryChannel && i.GuildID == "" {
+		respondEphemeral(s, i, logger, "⚠️ チャンネル通知はサーバー内でのみ登録できます")
+		return
+	}
+
+	sub := &models.Subscription{
+		UserID:    interactionUserID(i),
+		GuildID:   i.GuildID,
+		Kind:      optionString(options, "type"),
+		Pattern:   optionString(options, "pattern"),
+		Delivery:  delivery,
+		ChannelID: i.ChannelID,
+	}
+	if err := deps.Subscriptions.Add(sub); err != nil {
+		if !errors.Is(err, services.ErrSubscriptionExists) && !errors.Is(err, services.ErrSubscriptionLimit) {
+			logger.Warn("購読の登録に失敗しました", zap.String("user", interactionUser(i)), zap.Error(err))
+		}
+		respondEphemeral(s, i, logger, "⚠️ "+err.Error())
+		return
+	}
+
+	logger.Info("購読を登録しました",
+		zap.String("user", interactionUser(i)),
+		zap.String("kind", sub.Kind),
+		zap.String("pattern", sub.Pattern))
+	respondEphemeral(s, i, logger, fmt.Sprintf("✅ 購読を登録しました (ID: %d)\n%s `%s` → %s",
+		sub.ID, kindLabel(sub.Kind), sub.Pattern, deliveryLabel(sub.Delivery)))
+}
+
+func handleSubscribeRemove(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	id := optionInt(commandOptions(i), "id")
+	if id <= 0 {
+		respondEphemeral(s, i, logger, "⚠️ IDを指定してください")
+		return
+	}
+
+	err := deps.Subscriptions.Remove(interactionUserID(i), i.GuildID, uint(id))
+	if errors.Is(err, services.ErrSubscriptionNotFound) {
+		respondEphemeral(s, i, logger, fmt.Sprintf("⚠️ ID %d の購読は見つかりませんでした", id))
+		return
+	}
+	if err != nil {
+		logger.Error("購読の解除に失敗しました", zap.Int64("id", id), zap.Error(err))
+		respondError(s, i, logger, false, "購読の解除に失敗しました")
+		return
+	}
+	respondEphemeral(s, i, logger, fmt.Sprintf("🗑 購読 (ID: %d) を解除しました", id))
+}
+
+func handleSubscribeList(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	subs, err := deps.Subscriptions.List(interactionUserID(i), i.GuildID)
+	if err != nil {
+		logger.Error("購読の取得に失敗しました", zap.Error(err))
+		respondError(s, i, logger, false, "購読の取得に失敗しました")
+		return
+	}
+	if len(subs) == 0 {
+		respondEphemeral(s, i, logger, "📭 登録中の購読はありません。/subscribe add で登録できます")
+		return
+	}
+
+	var b strings.Builder
+	for _, sub := range subs {
+		fmt.Fprintf(&b, "`%d` %s `%s` → %s\n", sub.ID, kindLabel(sub.Kind), sub.Pattern, deliveryLabel(sub.Delivery))
+	}
+	respondEphemeral(s, i, logger, truncate("📋 **登録中の購読**\n"+b.String(), 1900))
+}
+
+func kindLabel(kind string) string {
+	switch kind {
+	case models.SubscriptionRegex:
+		return "正規表現"
+	case models.SubscriptionCode:
+		return "銘柄コード"
+	}
+	return "キーワード"
+}
+
+func deliveryLabel(delivery string) string {
+	if delivery == models.DeliveryChannel {
+		return "チャンネル"
+	}
+	return "DM"
+}
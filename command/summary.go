@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"bot/config"
+	"bot/services"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// handleSummary は記事のAI要約を返します。保存済みの要約があればAPIは呼びません。
+func handleSummary(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	rawURL := optionString(commandOptions(i), "url")
+
+	article, err := deps.Summary.FindArticleByURL(ctx, rawURL)
+	if errors.Is(err, services.ErrArticleNotFound) {
+		editResponse(s, i, logger, &discordgo.WebhookEdit{Content: ptr("⚠️ 取得済みの記事にそのURLは見つかりませんでした")})
+		return
+	}
+	if err != nil {
+		logger.Error("記事の検索に失敗しました", zap.String("url", rawURL), zap.Error(err))
+		editResponse(s, i, logger, &discordgo.WebhookEdit{Content: ptr("⚠️ 記事の検索に失敗しました")})
+		return
+	}
+
+	cached, err := deps.Summary.GenerateAndStoreSummary(ctx, article)
+	if err != nil {
+		editResponse(s, i, logger, &discordgo.WebhookEdit{Content: ptr(fmt.Sprintf("⚠️ %s", truncate(err.Error(), 300)))})
+		return
+	}
+
+	footer := "🤖 AIによる要約"
+	if cached {
+		footer = "💾 保存済みの要約"
+	}
+	embeds := []*discordgo.MessageEmbed{{
+		Author: &discordgo.MessageEmbedAuthor{
+			Name: fmt.Sprintf("📝 AI要約 - %s", article.Category),
+		},
+		Title:       truncate(article.Title, 250),
+		URL:         article.URL,
+		Description: truncate(article.Summary, 4000),
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "公開日時", Value: discordTime(article.PublishedAt, "f"), Inline: true},
+		},
+		Color:     0x9B59B6,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Footer:    &discordgo.MessageEmbedFooter{Text: footer + " | Bot " + config.Version},
+	}}
+	editResponse(s, i, logger, &discordgo.WebhookEdit{Embeds: &embeds})
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
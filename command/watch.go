@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"bot/models"
+	"bot/services"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+var watchDigestChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "毎日", Value: models.WatchDaily},
+	{Name: "毎時", Value: models.WatchHourly},
+	{Name: "送らない", Value: models.WatchOff},
+}
+
+func handleWatchAdd(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	options := commandOptions(i)
+	userID := interactionUserID(i)
+
+	code, err := deps.Watchlist.Add(userID, optionString(options, "code"))
+	if err != nil && !errors.Is(err, services.ErrWatchExists) {
+		if !errors.Is(err, services.ErrWatchLimit) {
+			logger.Warn("ウォッチリストの追加に失敗しました", zap.String("user", interactionUser(i)), zap.Error(err))
+		}
+		respondEphemeral(s, i, logger, "⚠️ "+err.Error())
+		return
+	}
+
+	message := fmt.Sprintf("✅ %s をウォッチリストに追加しました", code)
+	if errors.Is(err, services.ErrWatchExists) {
+		message = fmt.Sprintf("ℹ️ %s は既にウォッチリストに登録されています", code)
+	}
+	if freq := optionString(options, "digest"); freq != "" {
+		if err := deps.Watchlist.SetFrequency(userID, freq); err != nil {
+			logger.Error("ダイジェスト頻度の更新に失敗しました", zap.Error(err))
+			respondError(s, i, logger, false, "ダイジェスト頻度の更新に失敗しました")
+			return
+		}
+		message += fmt.Sprintf("\nダイジェスト: %s", digestLabel(freq))
+	}
+	respondEphemeral(s, i, logger, message)
+}
+
+func handleWatchRemove(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	code, err := deps.Watchlist.Remove(interactionUserID(i), optionString(commandOptions(i), "code"))
+	if err != nil {
+		respondEphemeral(s, i, logger, "⚠️ "+err.Error())
+		return
+	}
+	respondEphemeral(s, i, logger, fmt.Sprintf("🗑 %s をウォッチリストから削除しました", code))
+}
+
+func handleWatchList(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, logger *zap.Logger) {
+	userID := interactionUserID(i)
+	if freq := optionString(commandOptions(i), "digest"); freq != "" {
+		if err := deps.Watchlist.SetFrequency(userID, freq); err != nil {
+			logger.Error("ダイジェスト頻度の更新に失敗しました", zap.Error(err))
+			respondError(s, i, logger, false, "ダイジェスト頻度の更新に失敗しました")
+			return
+		}
+	}
+
+	items, freq, err := deps.Watchlist.List(userID)
+	if err != nil {
+		logger.Error("ウォッチリストの取得に失敗しました", zap.Error(err))
+		respondError(s, i, logger, false, "ウォッチリストの取得に失敗しました")
+		return
+	}
+	if len(items) == 0 {
+		respondEphemeral(s, i, logger, "📭 ウォッチリストは空です。/watch add で銘柄を追加できます")
+		return
+	}
+
+	codes := make([]string, 0, len(items))
+	for _, item := range items {
+		codes = append(codes, fmt.Sprintf("[%s](<https://kabutan.jp/stock/?code=%s>)", item.StockCode, item.StockCode))
+	}
+	respondEphemeral(s, i, logger, truncate(fmt.Sprintf("👀 **ウォッチリスト** (%d銘柄 / ダイジェスト: %s)\n%s",
+		len(items), digestLabel(freq), strings.Join(codes, " ")), 1900))
+}
+
+func digestLabel(freq string) string {
+	for _, c := range watchDigestChoices {
+		if c.Value == freq {
+			return c.Name
+		}
+	}
+	return freq
+}
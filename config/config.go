@@ -2,13 +2,22 @@ package config
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
-var logger *zap.Logger
+// Version は Bot のバージョンです。起動ログ・/version・通知のフッターはすべてこの値を使います。
+const Version = "v1.2.2"
+
+var (
+	logger   *zap.Logger
+	logLevel = zap.NewAtomicLevelAt(zap.InfoLevel)
+)
 
 type Config struct {
 	Discord          DiscordConfig   `mapstructure:"discord"`
@@ -16,12 +25,23 @@ type Config struct {
 	FinancialMetrics FinancialConfig `mapstructure:"financial_metrics"`
 	AI               AIConfig        `mapstructure:"ai"`
 	Screening        ScreeningConfig `mapstructure:"screening"`
+	Database         DatabaseConfig  `mapstructure:"database"`
+}
+
+// DatabaseConfig は記事等の保存先です。Driver は sqlite（既定）または postgres。
+// sqlite で DSN が空の場合は scraping.article_storage のパスを使います。
+type DatabaseConfig struct {
+	Driver       string        `mapstructure:"driver"`
+	DSN          string        `mapstructure:"dsn"`
+	BusyTimeout  time.Duration `mapstructure:"busy_timeout"`
+	MaxOpenConns int           `mapstructure:"max_open_conns"`
 }
 
 type DiscordConfig struct {
 	Token       string `yaml:"token"`
 	AlertChannel string `yaml:"alert_channel"`
 	LogChannel  string `yaml:"log_channel"`
+	DigestChannel string `yaml:"digest_channel"`
 }
 
 type AIConfig struct {
@@ -30,6 +50,16 @@ type AIConfig struct {
 	Endpoint    string `mapstructure:"endpoint"`
 	Model       string `mapstructure:"model"`
 	Timeout     int    `mapstructure:"timeout"`
+	// MaxInputChars は要約ジョブで1リクエストに含める記事テキストの上限文字数
+	MaxInputChars int           `mapstructure:"max_input_chars"`
+	DigestWindow  time.Duration `mapstructure:"digest_window"`
+
+	// リトライとサーキットブレーカー
+	MaxRetries       int           `mapstructure:"max_retries"`
+	RetryBaseDelay   time.Duration `mapstructure:"retry_base_delay"`
+	RetryMaxDelay    time.Duration `mapstructure:"retry_max_delay"`
+	BreakerThreshold int           `mapstructure:"breaker_threshold"`
+	BreakerCooldown  time.Duration `mapstructure:"breaker_cooldown"`
 }
 
 type ScrapingConfig struct {
@@ -43,6 +73,8 @@ type ScrapingConfig struct {
 	MaxPages      int `mapstructure:"max_pages"`
 	Parallelism   int `mapstructure:"parallelism"`
 	DelaySeconds int `mapstructure:"delay_seconds"`
+
+	ErrorStreakThreshold int `mapstructure:"error_streak_threshold"`
 }
 
 type FinancialConfig struct {
@@ -63,22 +95,55 @@ type ScreeningConfig struct {
 func InitConfig() {
 	viper.SetConfigName("config")
 	viper.AddConfigPath("configs")
+	// DISCORD_TOKEN → discord.token のように、環境変数をネストしたキーへ対応付ける
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
+	viper.SetDefault("scraping.error_streak_threshold", 3)
+	// DATABASE_DSN などの環境変数だけで指定できるよう、キーを既知にしておく
+	viper.SetDefault("database.driver", "sqlite")
+	viper.SetDefault("database.dsn", "")
+	viper.SetDefault("database.busy_timeout", "5s")
+	viper.SetDefault("database.max_open_conns", 0)
+	viper.SetDefault("watchlist.schedules.hourly", "5 * * * *")
+	viper.SetDefault("watchlist.schedules.daily", "0 18 * * *")
 	
 	if err := viper.ReadInConfig(); err != nil {
 		GetLogger().Fatal("設定ファイルの読み込みに失敗しました", zap.Error(err))
 	}
+	if err := mergeSecrets(); err != nil {
+		GetLogger().Fatal("シークレットの読み込みに失敗しました", zap.Error(err))
+	}
+	expandEnvValues()
+}
+
+// GetDatabaseConfig はデータベース設定を返します。
+func GetDatabaseConfig() DatabaseConfig {
+	cfg := DatabaseConfig{
+		Driver:       viper.GetString("database.driver"),
+		DSN:          viper.GetString("database.dsn"),
+		BusyTimeout:  viper.GetDuration("database.busy_timeout"),
+		MaxOpenConns: viper.GetInt("database.max_open_conns"),
+	}
+	if cfg.DSN == "" && (cfg.Driver == "" || strings.EqualFold(cfg.Driver, "sqlite")) {
+		cfg.DSN = viper.GetString("scraping.article_storage")
+	}
+	return cfg
 }
 
 func ValidateConfig() error {
 	required := []string{
 		"discord.token",
 		"scraping.kabutan_urls",
-		"ai.api_key",
+	}
+	// ローカルの Ollama やフェイクプロバイダーはAPIキー不要
+	switch strings.ToLower(viper.GetString("ai.provider")) {
+	case "ollama", "fake":
+	default:
+		required = append(required, "ai.api_key")
 	}
 
 	for _, key := range required {
-		if !viper.IsSet(key) {
+		if !viper.IsSet(key) || isEmpty(viper.Get(key)) {
 			return fmt.Errorf("必須設定が不足しています: %s", key)
 		}
 	}
@@ -90,14 +155,44 @@ func ValidateConfig() error {
 	return nil
 }
 
+// isEmpty は環境変数の展開結果が空の場合も未設定として扱うために使います。
+func isEmpty(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(val) == ""
+	case []interface{}:
+		return len(val) == 0
+	case []string:
+		return len(val) == 0
+	}
+	return false
+}
+
 func GetLogger() *zap.Logger {
 	if logger == nil {
-		logger, _ = zap.NewProduction(
+		cfg := zap.NewProductionConfig()
+		cfg.Level = logLevel
+		logger, _ = cfg.Build(
 			zap.Fields(
-				zap.String("version", "1.1.0"),
+				zap.String("version", Version),
 				zap.String("environment", viper.GetString("environment")),
 			),
 		)
 	}
 	return logger
 }
+
+// SetLogLevel は実行中のロガーのレベルを変更します。
+func SetLogLevel(level string) error {
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("無効なログレベル: %q (debug, info, warn, error のいずれか)", level)
+	}
+	if l < zapcore.DebugLevel || l > zapcore.ErrorLevel {
+		return fmt.Errorf("無効なログレベル: %q (debug, info, warn, error のいずれか)", level)
+	}
+	logLevel.SetLevel(l)
+	return nil
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// defaultSecretsFile は SECRETS_FILE 未指定時に読み込むシークレットファイルです。
+// リポジトリには含めず、configs/secrets.example.yaml を元に作成してください。
+const defaultSecretsFile = "configs/secrets.yaml"
+
+// envRef は ${VAR} または ${VAR:-既定値} 形式の参照です。
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// secretKeys に部分一致する設定キーはログや /config show で値を伏せる
+var secretKeys = []string{"token", "api_key", "password", "secret", "dsn"}
+
+// mergeSecrets はシークレットファイルが存在すれば設定にマージします。
+func mergeSecrets() error {
+	path := os.Getenv("SECRETS_FILE")
+	explicit := path != ""
+	if !explicit {
+		path = defaultSecretsFile
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return nil
+		}
+		return fmt.Errorf("シークレットファイルを開けません: %w", err)
+	}
+
+	secrets := viper.New()
+	secrets.SetConfigFile(path)
+	if err := secrets.ReadInConfig(); err != nil {
+		return fmt.Errorf("シークレットファイルの読み込みに失敗しました: %w", err)
+	}
+	if err := viper.MergeConfigMap(secrets.AllSettings()); err != nil {
+		return fmt.Errorf("シークレットファイルのマージに失敗しました: %w", err)
+	}
+	GetLogger().Info("シークレットファイルを読み込みました", zap.String("path", path))
+	return nil
+}
+
+// expandEnvValues は全ての文字列設定値に含まれる ${VAR} を環境変数の値に置き換えます。
+func expandEnvValues() {
+	for _, key := range viper.AllKeys() {
+		switch v := viper.Get(key).(type) {
+		case string:
+			if expanded := expandEnv(key, v); expanded != v {
+				viper.Set(key, expanded)
+			}
+		case []interface{}:
+			changed := false
+			out := make([]interface{}, len(v))
+			for i, item := range v {
+				out[i] = item
+				if s, ok := item.(string); ok {
+					if expanded := expandEnv(key, s); expanded != s {
+						out[i] = expanded
+						changed = true
+					}
+				}
+			}
+			if changed {
+				viper.Set(key, out)
+			}
+		}
+	}
+}
+
+func expandEnv(key, s string) string {
+	return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		m := envRef.FindStringSubmatch(ref)
+		if val, ok := os.LookupEnv(m[1]); ok {
+			return val
+		}
+		if strings.Contains(ref, ":-") {
+			return m[2]
+		}
+		GetLogger().Warn("設定値が参照する環境変数が未設定です",
+			zap.String("key", key),
+			zap.String("env", m[1]))
+		return ""
+	})
+}
+
+// IsSecretKey は値を伏せるべき設定キーかどうかを返します。
+func IsSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact はシークレットをログ出力用に伏せ字にします。
+func Redact(s string) string {
+	if s == "" {
+		return "(未設定)"
+	}
+	return fmt.Sprintf("********(%d文字)", len(s))
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Migration は1つのスキーマ変更です。Version は連番で、一度リリースしたら変更しないでください。
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaVersion は適用済みのマイグレーションを記録します。
+type SchemaVersion struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// CurrentVersion は適用済みの最新バージョンを返します。未適用の場合は0です。
+func CurrentVersion(db *gorm.DB) (int, error) {
+	if err := db.AutoMigrate(&SchemaVersion{}); err != nil {
+		return 0, fmt.Errorf("schema_version テーブルの作成に失敗しました: %w", err)
+	}
+	var version int
+	if err := db.Model(&SchemaVersion{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return 0, fmt.Errorf("スキーマバージョンの取得に失敗しました: %w", err)
+	}
+	return version, nil
+}
+
+// Migrate は未適用のマイグレーションを順に適用します。各マイグレーションは個別のトランザクションで実行されます。
+func Migrate(db *gorm.DB, logger *zap.Logger) error {
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range sortedMigrations() {
+		if m.Version <= current {
+			continue
+		}
+		start := time.Now()
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersion{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("マイグレーション %03d_%s の適用に失敗しました: %w", m.Version, m.Name, err)
+		}
+		logger.Info("マイグレーションを適用しました",
+			zap.Int("version", m.Version),
+			zap.String("name", m.Name),
+			zap.Duration("duration", time.Since(start)))
+	}
+	return nil
+}
+
+// Rollback は target より新しいマイグレーションを新しい順に取り消します。
+func Rollback(db *gorm.DB, logger *zap.Logger, target int) error {
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+
+	ms := sortedMigrations()
+	for idx := len(ms) - 1; idx >= 0; idx-- {
+		m := ms[idx]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaVersion{}, "version = ?", m.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("マイグレーション %03d_%s の取り消しに失敗しました: %w", m.Version, m.Name, err)
+		}
+		logger.Info("マイグレーションを取り消しました",
+			zap.Int("version", m.Version),
+			zap.String("name", m.Name))
+	}
+	return nil
+}
+
+func sortedMigrations() []Migration {
+	ms := append([]Migration(nil), migrations...)
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return ms
+}
@@ -0,0 +1,277 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bot/config"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// schemaCheck はマイグレーションの適用後に存在するはずのテーブル・カラムです。
+type schemaCheck struct {
+	tables []string
+	// column は "テーブル.カラム"
+	column string
+	// sqliteOnly は SQLite でのみ作成するもの
+	sqliteOnly bool
+}
+
+// schemaChecks はマイグレーションごとのスキーマです。データのみを変更するマイグレーションは空です。
+var schemaChecks = map[int]schemaCheck{
+	1:  {tables: []string{"articles"}},
+	2:  {tables: []string{"site_settings"}},
+	3:  {column: "articles.stock_code"},
+	4:  {},
+	5:  {},
+	6:  {tables: []string{"articles_fts"}, sqliteOnly: true},
+	7:  {tables: []string{"subscriptions"}},
+	8:  {tables: []string{"watch_items", "watch_digests"}},
+	9:  {tables: []string{"companies", "article_tickers"}},
+	10: {tables: []string{"outbound_messages"}},
+	11: {column: "outbound_messages.user_id"},
+}
+
+// applies はこのデータベースで確認する対象があるかどうかを返します。
+func (c schemaCheck) applies(db *gorm.DB) bool {
+	if c.sqliteOnly && db.Dialector.Name() != "sqlite" {
+		return false
+	}
+	return len(c.tables) > 0 || c.column != ""
+}
+
+// missing は存在しないテーブル・カラムを返します。
+func (c schemaCheck) missing(db *gorm.DB) []string {
+	var missing []string
+	for _, name := range c.tables {
+		if !db.Migrator().HasTable(name) {
+			missing = append(missing, name)
+		}
+	}
+	if c.column != "" {
+		table, column, _ := strings.Cut(c.column, ".")
+		if !db.Migrator().HasTable(table) || !db.Migrator().HasColumn(table, column) {
+			missing = append(missing, c.column)
+		}
+	}
+	return missing
+}
+
+func latestVersion() int {
+	ms := sortedMigrations()
+	return ms[len(ms)-1].Version
+}
+
+// openSQLite は一時ディレクトリに空の SQLite データベースを作成します。
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := Open(config.DatabaseConfig{DSN: filepath.Join(t.TempDir(), "articles.db")}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { Close(db) })
+	return db
+}
+
+// openPostgres は TEST_POSTGRES_DSN のデータベースに接続します。
+// -tags postgres でビルドしていない場合や DSN が未設定の場合はスキップします。
+func openPostgres(t *testing.T) *gorm.DB {
+	t.Helper()
+	if _, ok := dialectors["postgres"]; !ok {
+		t.Skip("postgres ドライバなし (-tags postgres でビルドしてください)")
+	}
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN が未設定です")
+	}
+	db, err := Open(config.DatabaseConfig{Driver: "postgres", DSN: dsn}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	// 前回の実行の残りを消してから始め、終了後も空に戻す
+	if err := Rollback(db, zap.NewNop(), 0); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	t.Cleanup(func() {
+		Rollback(db, zap.NewNop(), 0)
+		db.Migrator().DropTable(&SchemaVersion{})
+		Close(db)
+	})
+	return db
+}
+
+func TestMigrationsSQLite(t *testing.T) {
+	testMigrations(t, openSQLite(t))
+}
+
+func TestMigrationsPostgres(t *testing.T) {
+	testMigrations(t, openPostgres(t))
+}
+
+// testMigrations はすべてのマイグレーションを適用した後、新しい順に1つずつ取り消しては適用し直し、
+// 各マイグレーションの Up と Down がスキーマを作成・削除することを確認します。
+func testMigrations(t *testing.T, db *gorm.DB) {
+	logger := zap.NewNop()
+	latest := latestVersion()
+	if len(schemaChecks) != len(migrations) {
+		t.Fatalf("schemaChecks has %d entries, want one per migration (%d)", len(schemaChecks), len(migrations))
+	}
+
+	if err := Migrate(db, logger); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	assertVersion(t, db, latest)
+	assertSchema(t, db, latest)
+	// 適用済みの場合は何もしない
+	if err := Migrate(db, logger); err != nil {
+		t.Fatalf("Migrate (again): %v", err)
+	}
+	assertVersion(t, db, latest)
+
+	for v := latest; v >= 1; v-- {
+		if err := Rollback(db, logger, v-1); err != nil {
+			t.Fatalf("Rollback to %d: %v", v-1, err)
+		}
+		assertVersion(t, db, v-1)
+		assertSchema(t, db, v-1)
+		if c := schemaChecks[v]; c.applies(db) && len(c.missing(db)) == 0 {
+			t.Errorf("migration %d: schema still exists after Down", v)
+		}
+
+		// 取り消した分を適用し直せることを確認してから、次のバージョンに進む
+		if err := Migrate(db, logger); err != nil {
+			t.Fatalf("Migrate after rollback to %d: %v", v-1, err)
+		}
+		assertVersion(t, db, latest)
+		assertSchema(t, db, latest)
+		if err := Rollback(db, logger, v-1); err != nil {
+			t.Fatalf("Rollback to %d (again): %v", v-1, err)
+		}
+	}
+}
+
+func assertVersion(t *testing.T, db *gorm.DB, want int) {
+	t.Helper()
+	got, err := CurrentVersion(db)
+	if err != nil {
+		t.Fatalf("CurrentVersion: %v", err)
+	}
+	if got != want {
+		t.Fatalf("CurrentVersion = %d, want %d", got, want)
+	}
+}
+
+// assertSchema は version までのマイグレーションのスキーマが存在することを確認します。
+func assertSchema(t *testing.T, db *gorm.DB, version int) {
+	t.Helper()
+	for v := 1; v <= version; v++ {
+		if c := schemaChecks[v]; c.applies(db) {
+			if missing := c.missing(db); len(missing) > 0 {
+				t.Fatalf("at version %d, migration %d: missing %v", version, v, missing)
+			}
+		}
+	}
+}
+
+// migrateTo は target までのマイグレーションだけを適用します。既存の DB に新しいマイグレーションを適用する場合の再現に使います。
+func migrateTo(t *testing.T, db *gorm.DB, target int) {
+	t.Helper()
+	if _, err := CurrentVersion(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range sortedMigrations() {
+		if m.Version > target {
+			break
+		}
+		if err := m.Up(db); err != nil {
+			t.Fatalf("migration %d: %v", m.Version, err)
+		}
+		if err := db.Create(&SchemaVersion{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrationsBackfillSQLite(t *testing.T) {
+	db := openSQLite(t)
+	logger := zap.NewNop()
+	migrateTo(t, db, 3)
+
+	now := time.Now()
+	// Site が未設定だった頃の記事と、別テーブルだった traders の記事
+	setup := []struct {
+		sql  string
+		args []interface{}
+	}{
+		{`INSERT INTO articles (title, url, hash, content, category, stock_code, published_at, created_at, updated_at, last_scraped_at, retry_count)
+			VALUES ('IR記事', 'https://kabutan.jp/disclosures/1', 'h1', 'IRカテゴリ: 決算', '決算', '7203', ?, ?, ?, ?, 0)`,
+			[]interface{}{now, now, now, now}},
+		{`INSERT INTO articles (title, url, hash, content, category, published_at, created_at, updated_at, last_scraped_at, retry_count)
+			VALUES ('市場ニュース', 'https://kabutan.jp/news/1', 'h2', 'カテゴリ: 市況', '市況', ?, ?, ?, ?, 0)`,
+			[]interface{}{now, now, now, now}},
+		{`CREATE TABLE traders_articles (id integer primary key, title text, url text, hash text, category text, published_at datetime, created_at datetime)`,
+			nil},
+		{`INSERT INTO traders_articles (title, url, hash, category, published_at, created_at)
+			VALUES ('トレーダーズ記事', 'https://www.traders.co.jp/news/1', 'h3', '国内', ?, ?)`,
+			[]interface{}{now, now}},
+	}
+	for _, st := range setup {
+		if err := db.Exec(st.sql, st.args...).Error; err != nil {
+			t.Fatalf("%s: %v", st.sql, err)
+		}
+	}
+
+	if err := Migrate(db, logger); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	sites := map[string]string{}
+	rows, err := db.Raw(`SELECT url, site FROM articles`).Rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var url, site string
+		rows.Scan(&url, &site)
+		sites[url] = site
+	}
+	rows.Close()
+	want := map[string]string{
+		"https://kabutan.jp/disclosures/1": "ir",
+		"https://kabutan.jp/news/1":        "kabutan",
+		"https://www.traders.co.jp/news/1": "traders",
+	}
+	for url, site := range want {
+		if sites[url] != site {
+			t.Errorf("site of %s = %q, want %q", url, sites[url], site)
+		}
+	}
+
+	var tickers int64
+	db.Table("article_tickers").Where("stock_code = ? AND source = ?", "7203", "site").Count(&tickers)
+	if tickers != 1 {
+		t.Errorf("article_tickers backfilled %d rows, want 1", tickers)
+	}
+
+	// 全文検索の索引に既存の記事が取り込まれている
+	var hits int64
+	db.Raw(`SELECT count(*) FROM articles_fts WHERE articles_fts MATCH ?`, "トレーダーズ").Scan(&hits)
+	if hits != 1 {
+		t.Errorf("articles_fts matched %d rows, want 1", hits)
+	}
+
+	// traders の取り消しで複製した行だけが消える
+	if err := Rollback(db, logger, 4); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	var count int64
+	db.Table("articles").Count(&count)
+	if count != 2 {
+		t.Errorf("articles after rolling back merge_traders_articles = %d, want 2", count)
+	}
+}
@@ -0,0 +1,313 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// マイグレーション内では models の構造体を直接使わず、その時点のスキーマを写した構造体を使う。
+// 後から models を変更しても過去のマイグレーションの結果が変わらないようにするため。
+
+type articleV1 struct {
+	ID            uint   `gorm:"primaryKey"`
+	Site          string `gorm:"index"`
+	Title         string
+	URL           string `gorm:"uniqueIndex;size:500"`
+	Hash          string `gorm:"uniqueIndex;size:64"`
+	Content       string
+	Body          string `gorm:"type:text"`
+	Summary       string `gorm:"type:text"`
+	Category      string
+	PublishedAt   time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	LastScrapedAt time.Time
+	RetryCount    int
+}
+
+func (articleV1) TableName() string { return "articles" }
+
+type siteSettingV1 struct {
+	Site      string `gorm:"primaryKey;size:50"`
+	Enabled   bool
+	Filter    *string `gorm:"size:500"`
+	UpdatedBy string  `gorm:"size:100"`
+	UpdatedAt time.Time
+}
+
+func (siteSettingV1) TableName() string { return "site_settings" }
+
+type articleV3 struct {
+	StockCode string `gorm:"index;size:10"`
+}
+
+func (articleV3) TableName() string { return "articles" }
+
+type subscriptionV7 struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    string `gorm:"size:32;uniqueIndex:idx_subscription_unique;index"`
+	GuildID   string `gorm:"size:32;uniqueIndex:idx_subscription_unique"`
+	Kind      string `gorm:"size:10;uniqueIndex:idx_subscription_unique"`
+	Pattern   string `gorm:"size:200;uniqueIndex:idx_subscription_unique"`
+	Delivery  string `gorm:"size:10"`
+	ChannelID string `gorm:"size:32"`
+	CreatedAt time.Time
+}
+
+func (subscriptionV7) TableName() string { return "subscriptions" }
+
+type watchItemV8 struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    string `gorm:"size:32;uniqueIndex:idx_watch_user_code"`
+	StockCode string `gorm:"size:10;uniqueIndex:idx_watch_user_code;index"`
+	CreatedAt time.Time
+}
+
+func (watchItemV8) TableName() string { return "watch_items" }
+
+type watchDigestV8 struct {
+	UserID     string `gorm:"primaryKey;size:32"`
+	Frequency  string `gorm:"size:10;index"`
+	LastSentAt time.Time
+	UpdatedAt  time.Time
+}
+
+func (watchDigestV8) TableName() string { return "watch_digests" }
+
+type companyV9 struct {
+	Code      string `gorm:"primaryKey;size:10"`
+	Name      string `gorm:"size:200;index"`
+	Market    string `gorm:"size:50"`
+	UpdatedAt time.Time
+}
+
+func (companyV9) TableName() string { return "companies" }
+
+type articleTickerV9 struct {
+	ArticleID uint   `gorm:"primaryKey"`
+	StockCode string `gorm:"primaryKey;size:10;index"`
+	Source    string `gorm:"size:10"`
+	CreatedAt time.Time
+}
+
+func (articleTickerV9) TableName() string { return "article_tickers" }
+
+type outboundMessageV10 struct {
+	ID            uint   `gorm:"primaryKey"`
+	ChannelID     string `gorm:"size:32;index"`
+	Payload       string `gorm:"type:text"`
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index"`
+	LastError     string    `gorm:"size:500"`
+	SentAt        *time.Time
+	FailedAt      *time.Time
+	CreatedAt     time.Time
+}
+
+func (outboundMessageV10) TableName() string { return "outbound_messages" }
+
+// outboundMessageV11 は DM の送信先ユーザーです。DM のチャンネルは送信時に開きます。
+type outboundMessageV11 struct {
+	UserID string `gorm:"size:32"`
+}
+
+func (outboundMessageV11) TableName() string { return "outbound_messages" }
+
+var migrations = []Migration{
+	{
+		// AutoMigrate 時代に作成済みの DB でもそのまま適用できる
+		Version: 1,
+		Name:    "create_articles",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&articleV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("articles")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_site_settings",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&siteSettingV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("site_settings")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_article_stock_code",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&articleV3{}, "StockCode") {
+				return nil
+			}
+			if err := tx.Migrator().AddColumn(&articleV3{}, "StockCode"); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateIndex(&articleV3{}, "StockCode")
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&articleV3{}, "StockCode") {
+				if err := tx.Migrator().DropIndex(&articleV3{}, "StockCode"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropColumn(&articleV3{}, "StockCode")
+		},
+	},
+	{
+		// 旧実装は Site を設定していなかったため、Content の接頭辞から推定する
+		Version: 4,
+		Name:    "backfill_article_site",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Exec(`UPDATE articles SET site = 'ir' WHERE (site = '' OR site IS NULL) AND content LIKE 'IRカテゴリ:%'`).Error; err != nil {
+				return err
+			}
+			return tx.Exec(`UPDATE articles SET site = 'kabutan' WHERE site = '' OR site IS NULL`).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	},
+	{
+		// traders_articles の行を articles に site = 'traders' として複製する。
+		// 元のテーブルは取り消し用に残す。
+		Version: 5,
+		Name:    "merge_traders_articles",
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasTable("traders_articles") {
+				return nil
+			}
+			return tx.Exec(`
+				INSERT INTO articles (site, title, url, hash, content, category, published_at, created_at, updated_at, retry_count)
+				SELECT 'traders', t.title, t.url, t.hash, 'カテゴリ: ' || t.category, t.category, t.published_at, t.created_at, t.created_at, 0
+				FROM traders_articles t
+				WHERE NOT EXISTS (SELECT 1 FROM articles a WHERE a.url = t.url OR a.hash = t.hash)`).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasTable("traders_articles") {
+				return nil
+			}
+			return tx.Exec(`DELETE FROM articles WHERE site = 'traders' AND url IN (SELECT url FROM traders_articles)`).Error
+		},
+	},
+	{
+		// 日本語は空白で区切られないため trigram トークナイザで3文字単位に索引する。
+		// PostgreSQL では作成せず、検索側で LIKE にフォールバックする
+		Version: 6,
+		Name:    "create_articles_fts",
+		Up: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "sqlite" {
+				return nil
+			}
+			for _, stmt := range articlesFTSUp {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "sqlite" {
+				return nil
+			}
+			for _, stmt := range articlesFTSDown {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create_subscriptions",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&subscriptionV7{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("subscriptions")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_watchlist",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&watchItemV8{}, &watchDigestV8{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("watch_digests", "watch_items")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "create_companies_and_article_tickers",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&companyV9{}, &articleTickerV9{}); err != nil {
+				return err
+			}
+			// 取得元が付与していた銘柄コードを関連テーブルに移す
+			return tx.Exec(`INSERT INTO article_tickers (article_id, stock_code, source, created_at)
+				SELECT id, stock_code, 'site', created_at FROM articles
+				WHERE stock_code IS NOT NULL AND stock_code <> ''`).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("article_tickers", "companies")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "create_outbound_messages",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&outboundMessageV10{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("outbound_messages")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add_outbound_user_id",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&outboundMessageV11{}, "UserID") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&outboundMessageV11{}, "UserID")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&outboundMessageV11{}, "UserID")
+		},
+	},
+}
+
+var articlesFTSUp = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS articles_fts USING fts5(
+		title, category, body, summary,
+		content='articles', content_rowid='id', tokenize='trigram'
+	)`,
+	`CREATE TRIGGER IF NOT EXISTS articles_fts_ai AFTER INSERT ON articles BEGIN
+		INSERT INTO articles_fts(rowid, title, category, body, summary)
+		VALUES (new.id, new.title, new.category, new.body, new.summary);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS articles_fts_ad AFTER DELETE ON articles BEGIN
+		INSERT INTO articles_fts(articles_fts, rowid, title, category, body, summary)
+		VALUES ('delete', old.id, old.title, old.category, old.body, old.summary);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS articles_fts_au AFTER UPDATE OF title, category, body, summary ON articles BEGIN
+		INSERT INTO articles_fts(articles_fts, rowid, title, category, body, summary)
+		VALUES ('delete', old.id, old.title, old.category, old.body, old.summary);
+		INSERT INTO articles_fts(rowid, title, category, body, summary)
+		VALUES (new.id, new.title, new.category, new.body, new.summary);
+	END`,
+	// 既存の記事を索引に取り込む
+	`INSERT INTO articles_fts(articles_fts) VALUES ('rebuild')`,
+}
+
+var articlesFTSDown = []string{
+	`DROP TRIGGER IF EXISTS articles_fts_au`,
+	`DROP TRIGGER IF EXISTS articles_fts_ad`,
+	`DROP TRIGGER IF EXISTS articles_fts_ai`,
+	`DROP TABLE IF EXISTS articles_fts`,
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"bot/config"
+
+	"github.com/glebarez/sqlite"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultSQLitePath は database.dsn と scraping.article_storage がどちらも未設定の場合の保存先
+	defaultSQLitePath  = "articles.db"
+	defaultBusyTimeout = 5 * time.Second
+)
+
+// dialectors はドライバ名ごとの gorm.Dialector の生成関数です。
+// SQLite 以外はビルドタグ付きのファイルで登録します（postgres.go を参照）。
+var dialectors = map[string]func(cfg config.DatabaseConfig) (gorm.Dialector, error){
+	"sqlite": sqliteDialector,
+}
+
+// Open は設定に従ってデータベースに接続します。マイグレーションは行いません。
+func Open(cfg config.DatabaseConfig, logger *zap.Logger) (*gorm.DB, error) {
+	driver := strings.ToLower(cfg.Driver)
+	if driver == "" {
+		driver = "sqlite"
+	}
+	newDialector, ok := dialectors[driver]
+	if !ok {
+		return nil, fmt.Errorf("未対応のデータベースドライバです: %s (利用可能: %s)", cfg.Driver, strings.Join(Drivers(), ", "))
+	}
+
+	dialector, err := newDialector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("データベース接続に失敗しました: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+
+	logger.Info("データベースに接続しました", zap.String("driver", driver))
+	return db, nil
+}
+
+// Close はデータベース接続を閉じます。
+func Close(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Drivers は利用可能なドライバ名を返します。
+func Drivers() []string {
+	names := make([]string, 0, len(dialectors))
+	for name := range dialectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sqliteDialector は WAL モードと busy_timeout を付与した SQLite の接続を作成します。
+// colly のコールバックから並行して書き込むため、ロック競合時は即時エラーにせず待機させます。
+func sqliteDialector(cfg config.DatabaseConfig) (gorm.Dialector, error) {
+	path := cfg.DSN
+	if path == "" {
+		path = defaultSQLitePath
+	}
+
+	if !strings.HasPrefix(path, "file:") && path != ":memory:" {
+		if dir := filepath.Dir(path); dir != "." {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return nil, fmt.Errorf("データベースのディレクトリ作成に失敗しました: %w", err)
+			}
+		}
+	}
+
+	busy := cfg.BusyTimeout
+	if busy <= 0 {
+		busy = defaultBusyTimeout
+	}
+
+	params := url.Values{}
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busy.Milliseconds()))
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "synchronous(NORMAL)")
+	params.Add("_pragma", "foreign_keys(ON)")
+	// 読み取りから書き込みへの昇格時のデッドロックを避けるため、トランザクション開始時に書き込みロックを取る
+	params.Set("_txlock", "immediate")
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return sqlite.Open(path + sep + params.Encode()), nil
+}
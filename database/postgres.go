@@ -0,0 +1,22 @@
+//go:build postgres
+
+package database
+
+import (
+	"fmt"
+
+	"bot/config"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// PostgreSQL ドライバは依存を増やさないよう、-tags postgres でビルドした場合のみ有効になります。
+func init() {
+	dialectors["postgres"] = func(cfg config.DatabaseConfig) (gorm.Dialector, error) {
+		if cfg.DSN == "" {
+			return nil, fmt.Errorf("database.dsn が設定されていません")
+		}
+		return postgres.Open(cfg.DSN), nil
+	}
+}
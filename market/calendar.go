@@ -0,0 +1,252 @@
+// Package market は東京証券取引所の営業日と立会時間を扱います。
+package market
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed calendar.yaml
+var defaultCalendar []byte
+
+// Window はタスクを実行してよい時間帯です。複数指定した場合はいずれかに該当すれば実行します。
+// ゼロ値は時間帯の制限なしを表します。
+type Window uint8
+
+const (
+	// WindowMarket は営業日の立会時間中（昼休みを除く）
+	WindowMarket Window = 1 << iota
+	// WindowPreOpen は営業日の寄り前
+	WindowPreOpen
+	// WindowAfterClose は営業日の引け後
+	WindowAfterClose
+
+	// WindowAlways は時間帯の制限なし
+	WindowAlways Window = 0
+)
+
+var windowNames = []struct {
+	w    Window
+	name string
+}{
+	{WindowPreOpen, "pre_open"},
+	{WindowMarket, "market"},
+	{WindowAfterClose, "after_close"},
+}
+
+// ParseWindow は "market" や "pre_open,after_close" のような設定値を Window に変換します。
+// 空文字列と "always" は制限なしです。
+func ParseWindow(s string) (Window, error) {
+	var w Window
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" || part == "always" {
+			continue
+		}
+		found := false
+		for _, wn := range windowNames {
+			if wn.name == part {
+				w |= wn.w
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("不明な時間帯です: %q (always, market, pre_open, after_close のいずれか)", part)
+		}
+	}
+	return w, nil
+}
+
+func (w Window) String() string {
+	if w == WindowAlways {
+		return "always"
+	}
+	var names []string
+	for _, wn := range windowNames {
+		if w&wn.w != 0 {
+			names = append(names, wn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Session は1日のうちの時間帯です。Start を含み End を含みません。
+type Session struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+
+	start, end time.Duration
+}
+
+func (s *Session) parse() error {
+	var err error
+	if s.start, err = parseClock(s.Start); err != nil {
+		return err
+	}
+	if s.end, err = parseClock(s.End); err != nil {
+		return err
+	}
+	if s.end <= s.start {
+		return fmt.Errorf("終了時刻が開始時刻以前です: %s-%s", s.Start, s.End)
+	}
+	return nil
+}
+
+func (s Session) contains(clock time.Duration) bool {
+	return clock >= s.start && clock < s.end
+}
+
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("時刻の形式が不正です: %q", v)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Calendar は東証の営業日と立会時間です。時刻はすべて Asia/Tokyo で判定します。
+type Calendar struct {
+	Sessions   []Session         `yaml:"sessions"`
+	PreOpen    Session           `yaml:"pre_open"`
+	AfterClose Session           `yaml:"after_close"`
+	YearEnd    []string          `yaml:"year_end"`
+	Holidays   map[string]string `yaml:"holidays"`
+
+	loc      *time.Location
+	yearEnd  map[string]bool
+	lastYear int
+}
+
+// Default は埋め込みのカレンダーを返します。
+func Default() *Calendar {
+	c, err := Parse(defaultCalendar)
+	if err != nil {
+		panic("埋め込みの市場カレンダーが不正です: " + err.Error())
+	}
+	return c
+}
+
+// Load は path のカレンダーを読み込みます。path が空の場合は埋め込みのカレンダーを返します。
+func Load(path string) (*Calendar, error) {
+	if path == "" {
+		return Default(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("市場カレンダーの読み込みに失敗しました: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse は calendar.yaml 形式のデータからカレンダーを作ります。
+func Parse(data []byte) (*Calendar, error) {
+	var c Calendar
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("市場カレンダーの解析に失敗しました: %w", err)
+	}
+	if len(c.Sessions) == 0 {
+		return nil, fmt.Errorf("市場カレンダーに立会時間がありません")
+	}
+	for idx := range c.Sessions {
+		if err := c.Sessions[idx].parse(); err != nil {
+			return nil, fmt.Errorf("立会時間: %w", err)
+		}
+	}
+	if err := c.PreOpen.parse(); err != nil {
+		return nil, fmt.Errorf("寄り前: %w", err)
+	}
+	if err := c.AfterClose.parse(); err != nil {
+		return nil, fmt.Errorf("引け後: %w", err)
+	}
+
+	c.yearEnd = make(map[string]bool, len(c.YearEnd))
+	for _, d := range c.YearEnd {
+		if _, err := time.Parse("01-02", d); err != nil {
+			return nil, fmt.Errorf("年末年始の日付が不正です: %q", d)
+		}
+		c.yearEnd[d] = true
+	}
+	for d := range c.Holidays {
+		t, err := time.Parse("2006-01-02", d)
+		if err != nil {
+			return nil, fmt.Errorf("祝日の日付が不正です: %q", d)
+		}
+		if t.Year() > c.lastYear {
+			c.lastYear = t.Year()
+		}
+	}
+
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		loc = time.FixedZone("JST", 9*3600)
+	}
+	c.loc = loc
+	return &c, nil
+}
+
+// Location は判定に使うタイムゾーン (Asia/Tokyo) を返します。
+func (c *Calendar) Location() *time.Location {
+	return c.loc
+}
+
+// CoveredUntil は祝日データが含まれる最後の年を返します。これより後の年は祝日を判定できません。
+func (c *Calendar) CoveredUntil() int {
+	return c.lastYear
+}
+
+// Holiday は t の日付が祝日または年末年始の休業日であれば、その名前を返します。
+func (c *Calendar) Holiday(t time.Time) (string, bool) {
+	t = t.In(c.loc)
+	if name, ok := c.Holidays[t.Format("2006-01-02")]; ok {
+		return name, true
+	}
+	if c.yearEnd[t.Format("01-02")] {
+		return "年末年始休業", true
+	}
+	return "", false
+}
+
+// IsTradingDay は t の日付が営業日かどうかを返します。
+func (c *Calendar) IsTradingDay(t time.Time) bool {
+	t = t.In(c.loc)
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	_, holiday := c.Holiday(t)
+	return !holiday
+}
+
+// InWindow は t が w の時間帯に含まれるかどうかを返します。
+func (c *Calendar) InWindow(w Window, t time.Time) bool {
+	if w == WindowAlways {
+		return true
+	}
+	if !c.IsTradingDay(t) {
+		return false
+	}
+	clock := sinceMidnight(t.In(c.loc))
+	if w&WindowPreOpen != 0 && c.PreOpen.contains(clock) {
+		return true
+	}
+	if w&WindowAfterClose != 0 && c.AfterClose.contains(clock) {
+		return true
+	}
+	if w&WindowMarket != 0 {
+		for _, s := range c.Sessions {
+			if s.contains(clock) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
@@ -0,0 +1,144 @@
+package market
+
+import (
+	"testing"
+	"time"
+)
+
+func jst(t *testing.T, c *Calendar, value string) time.Time {
+	t.Helper()
+	tm, err := time.ParseInLocation("2006-01-02 15:04", value, c.Location())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tm
+}
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Window
+		wantErr bool
+	}{
+		{"", WindowAlways, false},
+		{"always", WindowAlways, false},
+		{"market", WindowMarket, false},
+		{" Market ", WindowMarket, false},
+		{"pre_open,after_close", WindowPreOpen | WindowAfterClose, false},
+		{"market, pre_open, after_close", WindowMarket | WindowPreOpen | WindowAfterClose, false},
+		{"lunch", 0, true},
+		{"market,night", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseWindow(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseWindow(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+		if err == nil {
+			// String の結果を読み戻しても同じ値になる
+			if back, err := ParseWindow(got.String()); err != nil || back != got {
+				t.Errorf("ParseWindow(%q.String()) = %v, %v", got, back, err)
+			}
+		}
+	}
+}
+
+func TestInWindow(t *testing.T) {
+	c := Default()
+	tests := []struct {
+		name string
+		at   string
+		w    Window
+		want bool
+	}{
+		// 2025-06-02 は月曜日の営業日
+		{"before pre-open", "2025-06-02 07:59", WindowPreOpen, false},
+		{"pre-open start", "2025-06-02 08:00", WindowPreOpen, true},
+		{"pre-open is not market", "2025-06-02 08:59", WindowMarket, false},
+		{"morning session start", "2025-06-02 09:00", WindowMarket, true},
+		{"morning session start is not pre-open", "2025-06-02 09:00", WindowPreOpen, false},
+		{"morning session last minute", "2025-06-02 11:29", WindowMarket, true},
+		{"lunch break start", "2025-06-02 11:30", WindowMarket, false},
+		{"lunch break", "2025-06-02 12:00", WindowMarket, false},
+		{"lunch break last minute", "2025-06-02 12:29", WindowMarket, false},
+		{"afternoon session start", "2025-06-02 12:30", WindowMarket, true},
+		{"afternoon session last minute", "2025-06-02 15:29", WindowMarket, true},
+		{"close", "2025-06-02 15:30", WindowMarket, false},
+		{"after close start", "2025-06-02 15:30", WindowAfterClose, true},
+		{"after close end", "2025-06-02 20:00", WindowAfterClose, false},
+		{"combined window", "2025-06-02 12:00", WindowPreOpen | WindowAfterClose | WindowMarket, false},
+		{"combined window after close", "2025-06-02 16:00", WindowMarket | WindowAfterClose, true},
+		{"always at night", "2025-06-02 03:00", WindowAlways, true},
+
+		{"saturday", "2025-06-07 10:00", WindowMarket, false},
+		{"sunday", "2025-06-08 10:00", WindowMarket, false},
+		{"always on sunday", "2025-06-08 10:00", WindowAlways, true},
+		{"holiday (海の日)", "2025-07-21 10:00", WindowMarket, false},
+		{"holiday after close", "2025-07-21 16:00", WindowAfterClose, false},
+		{"year end 12-31", "2025-12-31 10:00", WindowMarket, false},
+		{"new year 01-03", "2025-01-03 10:00", WindowMarket, false},
+		{"first trading day 01-06", "2025-01-06 09:00", WindowMarket, true},
+		// 祝日データのない年でも年末年始は休業
+		{"new year outside holiday data", "2030-01-03 10:00", WindowMarket, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.InWindow(tt.w, jst(t, c, tt.at)); got != tt.want {
+				t.Errorf("InWindow(%v, %s) = %v, want %v", tt.w, tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestInWindowConvertsToJST はサーバーのタイムゾーンに関係なく日本時間で判定することを確認します。
+func TestInWindowConvertsToJST(t *testing.T) {
+	c := Default()
+	// 2025-06-02 00:30 UTC は 09:30 JST
+	if !c.InWindow(WindowMarket, time.Date(2025, 6, 2, 0, 30, 0, 0, time.UTC)) {
+		t.Error("00:30 UTC (09:30 JST) should be in market hours")
+	}
+	// 2025-06-06 (金) 16:00 UTC は土曜 01:00 JST
+	if c.IsTradingDay(time.Date(2025, 6, 6, 16, 0, 0, 0, time.UTC)) {
+		t.Error("Friday 16:00 UTC is Saturday in Japan")
+	}
+}
+
+func TestHoliday(t *testing.T) {
+	c := Default()
+	if name, ok := c.Holiday(jst(t, c, "2025-07-21 00:00")); !ok || name != "海の日" {
+		t.Errorf("Holiday(2025-07-21) = %q, %v", name, ok)
+	}
+	if name, ok := c.Holiday(jst(t, c, "2025-12-31 00:00")); !ok || name != "年末年始休業" {
+		t.Errorf("Holiday(2025-12-31) = %q, %v", name, ok)
+	}
+	if _, ok := c.Holiday(jst(t, c, "2025-06-02 00:00")); ok {
+		t.Error("2025-06-02 is not a holiday")
+	}
+}
+
+// TestCalendarCoversCurrentYear は埋め込みの祝日データが今年の分まであることを確認します。
+// 失敗した場合は calendar.yaml に内閣府の発表した祝日を追加してください。
+func TestCalendarCoversCurrentYear(t *testing.T) {
+	c := Default()
+	year := time.Now().In(c.Location()).Year()
+	if covered := c.CoveredUntil(); covered < year {
+		t.Errorf("calendar.yaml の祝日データは %d 年までしかありません（現在 %d 年）。祝日を追加してください", covered, year)
+	}
+}
+
+func TestParseRejectsInvalidCalendar(t *testing.T) {
+	tests := map[string]string{
+		"no sessions":      `pre_open: {start: "08:00", end: "09:00"}`,
+		"end before start": `sessions: [{start: "11:30", end: "09:00"}]`,
+		"bad clock":        `sessions: [{start: "9時", end: "11:30"}]`,
+		"bad holiday": `sessions: [{start: "09:00", end: "11:30"}]
+pre_open: {start: "08:00", end: "09:00"}
+after_close: {start: "15:30", end: "20:00"}
+holidays: {"2025/01/01": 元日}`,
+	}
+	for name, data := range tests {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("%s: Parse succeeded", name)
+		}
+	}
+}
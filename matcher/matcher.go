@@ -0,0 +1,127 @@
+// Package matcher は多数の固定文字列を1回の走査で検索する Aho-Corasick 法の実装です。
+package matcher
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Hit は一致した位置です。Start/End は Normalize 後の文字列でのバイト位置です。
+type Hit struct {
+	Pattern int // New に渡したパターンの添字
+	Start   int
+	End     int
+}
+
+type node struct {
+	next   map[byte]int32
+	fail   int32
+	output []int // このノードで終わるパターン（fail を辿った分も含む）
+}
+
+// Matcher は構築後は読み取り専用のため、複数の goroutine から同時に使えます。
+type Matcher struct {
+	nodes    []node
+	patterns []string
+}
+
+// New はパターンから検索用のオートマトンを構築します。パターンは Normalize してから登録されます。
+// 空のパターンは無視されます。
+func New(patterns []string) *Matcher {
+	m := &Matcher{
+		nodes:    []node{{next: map[byte]int32{}}},
+		patterns: make([]string, len(patterns)),
+	}
+	for idx, p := range patterns {
+		p = Normalize(p)
+		m.patterns[idx] = p
+		if p == "" {
+			continue
+		}
+		cur := int32(0)
+		for i := 0; i < len(p); i++ {
+			nxt, ok := m.nodes[cur].next[p[i]]
+			if !ok {
+				nxt = int32(len(m.nodes))
+				m.nodes = append(m.nodes, node{next: map[byte]int32{}})
+				m.nodes[cur].next[p[i]] = nxt
+			}
+			cur = nxt
+		}
+		m.nodes[cur].output = append(m.nodes[cur].output, idx)
+	}
+	m.build()
+	return m
+}
+
+// build は幅優先で失敗リンクを張ります。
+func (m *Matcher) build() {
+	queue := make([]int32, 0, len(m.nodes))
+	for _, child := range m.nodes[0].next {
+		m.nodes[child].fail = 0
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for b, child := range m.nodes[cur].next {
+			f := m.nodes[cur].fail
+			for f != 0 {
+				if _, ok := m.nodes[f].next[b]; ok {
+					break
+				}
+				f = m.nodes[f].fail
+			}
+			if nxt, ok := m.nodes[f].next[b]; ok && nxt != child {
+				m.nodes[child].fail = nxt
+			} else {
+				m.nodes[child].fail = 0
+			}
+			m.nodes[child].output = append(m.nodes[child].output, m.nodes[m.nodes[child].fail].output...)
+			queue = append(queue, child)
+		}
+	}
+}
+
+// FindAll は text に含まれるすべての一致を返します。text は内部で Normalize されます。
+func (m *Matcher) FindAll(text string) []Hit {
+	text = Normalize(text)
+	var hits []Hit
+	cur := int32(0)
+	for i := 0; i < len(text); i++ {
+		b := text[i]
+		for {
+			if nxt, ok := m.nodes[cur].next[b]; ok {
+				cur = nxt
+				break
+			}
+			if cur == 0 {
+				break
+			}
+			cur = m.nodes[cur].fail
+		}
+		for _, p := range m.nodes[cur].output {
+			hits = append(hits, Hit{Pattern: p, Start: i + 1 - len(m.patterns[p]), End: i + 1})
+		}
+	}
+	return hits
+}
+
+// Len は登録されたパターン数を返します。
+func (m *Matcher) Len() int {
+	return len(m.patterns)
+}
+
+// Normalize は全角英数字・記号を半角に、英字を小文字に揃えます。
+// 記事は全角・半角が混在するため、パターンと本文の両方に同じ変換をかけます。
+func Normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '！' && r <= '～':
+			r -= '！' - '!'
+		case r == '　':
+			r = ' '
+		}
+		return unicode.ToLower(r)
+	}, s)
+}
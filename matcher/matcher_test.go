@@ -0,0 +1,139 @@
+package matcher
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func findAll(m *Matcher, text string) []Hit {
+	hits := m.FindAll(text)
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].End != hits[j].End {
+			return hits[i].End < hits[j].End
+		}
+		return hits[i].Pattern < hits[j].Pattern
+	})
+	return hits
+}
+
+func TestFindAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		text     string
+		want     []Hit
+	}{
+		{
+			// 重なり合う一致と、失敗リンクを辿った先の出力
+			name:     "overlapping",
+			patterns: []string{"he", "she", "his", "hers"},
+			text:     "ushers",
+			want:     []Hit{{Pattern: 0, Start: 2, End: 4}, {Pattern: 1, Start: 1, End: 4}, {Pattern: 3, Start: 2, End: 6}},
+		},
+		{
+			// "abc" まで進んでから "bc" の枝に失敗リンクで移る
+			name:     "failure link to shared suffix",
+			patterns: []string{"abcd", "bce"},
+			text:     "abce",
+			want:     []Hit{{Pattern: 1, Start: 1, End: 4}},
+		},
+		{
+			name:     "repeated prefix",
+			patterns: []string{"aab", "ab"},
+			text:     "aaab",
+			want:     []Hit{{Pattern: 0, Start: 1, End: 4}, {Pattern: 1, Start: 2, End: 4}},
+		},
+		{
+			name:     "shared prefix",
+			patterns: []string{"トヨタ", "トヨタ自動車", "トヨタ紡織"},
+			text:     "トヨタ自動車が上方修正",
+			want:     []Hit{{Pattern: 0, Start: 0, End: 9}, {Pattern: 1, Start: 0, End: 18}},
+		},
+		{
+			name:     "pattern inside another",
+			patterns: []string{"上方修正", "修正"},
+			text:     "業績予想の上方修正",
+			want:     []Hit{{Pattern: 0, Start: 15, End: 27}, {Pattern: 1, Start: 21, End: 27}},
+		},
+		{
+			name:     "repeated matches",
+			patterns: []string{"aa"},
+			text:     "aaaa",
+			want:     []Hit{{Pattern: 0, Start: 0, End: 2}, {Pattern: 0, Start: 1, End: 3}, {Pattern: 0, Start: 2, End: 4}},
+		},
+		{
+			name:     "duplicate patterns report both",
+			patterns: []string{"決算", "決算"},
+			text:     "決算発表",
+			want:     []Hit{{Pattern: 0, Start: 0, End: 6}, {Pattern: 1, Start: 0, End: 6}},
+		},
+		{
+			name:     "case and width are normalized",
+			patterns: []string{"ＥＶ", "Toyota"},
+			text:     "TOYOTAの新型ev",
+			want:     []Hit{{Pattern: 1, Start: 0, End: 6}, {Pattern: 0, Start: 15, End: 17}},
+		},
+		{
+			name:     "empty pattern is ignored",
+			patterns: []string{"", "株"},
+			text:     "株式",
+			want:     []Hit{{Pattern: 1, Start: 0, End: 3}},
+		},
+		{
+			name:     "no match",
+			patterns: []string{"増配", "自社株買い"},
+			text:     "減配を発表",
+			want:     nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(tt.patterns)
+			if got := findAll(m, tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindAll(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+			if m.Len() != len(tt.patterns) {
+				t.Errorf("Len() = %d, want %d", m.Len(), len(tt.patterns))
+			}
+		})
+	}
+}
+
+// TestFindAllMatchesNaive は多数の重なり合うパターンで、単純な検索と結果が一致することを確認します。
+func TestFindAllMatchesNaive(t *testing.T) {
+	patterns := []string{"a", "ab", "abc", "b", "bc", "bca", "c", "cab", "abcab", "ca", "aa"}
+	text := "abcabcaabcab"
+	m := New(patterns)
+
+	var want []Hit
+	for end := 1; end <= len(text); end++ {
+		for idx, p := range patterns {
+			if start := end - len(p); start >= 0 && text[start:end] == p {
+				want = append(want, Hit{Pattern: idx, Start: start, End: end})
+			}
+		}
+	}
+	if got := findAll(m, text); !reflect.DeepEqual(got, want) {
+		t.Errorf("FindAll = %v\nwant %v", got, want)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"ＡＢＣ１２３": "abc123",
+		"Ｔｏｙｏｔａ": "toyota",
+		"ＥＶ　関連":  "ev 関連",
+		"（株）＆！":  "(株)&!",
+		"ABC":    "abc",
+		"トヨタ自動車": "トヨタ自動車",
+		"ｶﾀｶﾅ":   "ｶﾀｶﾅ",
+		"～":      "~",
+		"":       "",
+	}
+	for in, want := range tests {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Article は全サイト共通の記事です。Site でどのソースから取得したかを区別します。
+type Article struct {
+	ID            uint   `gorm:"primaryKey"`
+	Site          string `gorm:"index"`
+	Title         string
+	URL           string `gorm:"uniqueIndex;size:500"`
+	Hash          string `gorm:"uniqueIndex;size:64"`
+	Content       string
+	Body          string `gorm:"type:text"`
+	Summary       string `gorm:"type:text"`
+	Category      string
+	StockCode     string `gorm:"index;size:10"`
+	PublishedAt   time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	LastScrapedAt time.Time // 最終スクレイピング日時を追跡
+	RetryCount    int       // リトライ回数を追跡
+}
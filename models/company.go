@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Company は上場銘柄マスタです。CSV から取り込みます。
+type Company struct {
+	Code      string `gorm:"primaryKey;size:10"`
+	Name      string `gorm:"size:200;index"`
+	Market    string `gorm:"size:50"`
+	UpdatedAt time.Time
+}
+
+// 記事と銘柄を結び付けた根拠
+const (
+	TickerFromSite = "site" // 一覧ページの data-code など取得元が付与したコード
+	TickerFromCode = "code" // 本文中の (7203) のような表記
+	TickerFromName = "name" // 銘柄マスタの社名との一致
+)
+
+// ArticleTicker は記事と銘柄の多対多の関連です。
+type ArticleTicker struct {
+	ArticleID uint   `gorm:"primaryKey"`
+	StockCode string `gorm:"primaryKey;size:10;index"`
+	Source    string `gorm:"size:10"`
+	CreatedAt time.Time
+}
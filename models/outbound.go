@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// OutboundMessage は送信待ちの Discord メッセージです。送信に成功するか諦めるまで DB に残り、再起動後も送信されます。
+// Payload は services.OutboundPayload の JSON です。DM の場合は ChannelID が空で、UserID に送信先を持ちます。
+type OutboundMessage struct {
+	ID            uint   `gorm:"primaryKey"`
+	ChannelID     string `gorm:"size:32;index"`
+	UserID        string `gorm:"size:32"`
+	Payload       string `gorm:"type:text"`
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index"`
+	LastError     string    `gorm:"size:500"`
+	SentAt        *time.Time
+	FailedAt      *time.Time
+	CreatedAt     time.Time
+}
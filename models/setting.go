@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// SiteSetting はサイトごとの実行時設定です。再起動後も保持されます。
+type SiteSetting struct {
+	Site    string `gorm:"primaryKey;size:50"`
+	Enabled bool
+	// Filter は一覧ページに付与するクエリ文字列。NULL の場合は設定ファイルの値を使う
+	Filter    *string `gorm:"size:500"`
+	UpdatedBy string  `gorm:"size:100"`
+	UpdatedAt time.Time
+}
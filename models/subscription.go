@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// 購読の種類
+const (
+	SubscriptionKeyword = "keyword"
+	SubscriptionRegex   = "regex"
+	SubscriptionCode    = "code"
+)
+
+// 通知方法
+const (
+	DeliveryDM      = "dm"
+	DeliveryChannel = "channel"
+)
+
+// Subscription はユーザーごとのキーワード・正規表現・銘柄コードの購読です。
+type Subscription struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserID   string `gorm:"size:32;uniqueIndex:idx_subscription_unique;index"`
+	GuildID  string `gorm:"size:32;uniqueIndex:idx_subscription_unique"`
+	Kind     string `gorm:"size:10;uniqueIndex:idx_subscription_unique"`
+	Pattern  string `gorm:"size:200;uniqueIndex:idx_subscription_unique"`
+	Delivery string `gorm:"size:10"`
+	// ChannelID は登録したチャンネル。discord.subscription_channel が未設定の場合の通知先
+	ChannelID string `gorm:"size:32"`
+	CreatedAt time.Time
+}
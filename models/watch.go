@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// ウォッチリストのダイジェスト送信頻度
+const (
+	WatchDaily  = "daily"
+	WatchHourly = "hourly"
+	WatchOff    = "off"
+)
+
+// WatchItem はユーザーがウォッチしている銘柄です。
+type WatchItem struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    string `gorm:"size:32;uniqueIndex:idx_watch_user_code"`
+	StockCode string `gorm:"size:10;uniqueIndex:idx_watch_user_code;index"`
+	CreatedAt time.Time
+}
+
+// WatchDigest はユーザーごとのダイジェスト送信設定と前回の送信時刻です。
+type WatchDigest struct {
+	UserID     string `gorm:"primaryKey;size:32"`
+	Frequency  string `gorm:"size:10;index"`
+	LastSentAt time.Time
+	UpdatedAt  time.Time
+}
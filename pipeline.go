@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bot/models"
+	"bot/routing"
+	"bot/services"
+	"bot/sources"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// pipeline は取得した記事の保存と通知に必要な依存をまとめたものです。
+type pipeline struct {
+	logger        *zap.Logger
+	settings      *services.SettingsStore
+	subscriptions *services.SubscriptionService
+	tickers       *services.TickerService
+	bodies        *services.BodyFetcher
+	notifier      *services.Notifier
+	router        *routing.Router
+}
+
+// savedArticle は保存した記事と、保存後に抽出した銘柄コードです。
+type savedArticle struct {
+	sources.Item
+	Article *models.Article
+	Codes   []string
+}
+
+// runSource はソースから記事を取得し、新着のみを保存して通知します。
+func (p *pipeline) runSource(ctx context.Context, entry sources.Entry) (int, error) {
+	items, fetchErr := entry.Source.Fetch(ctx, sources.FetchOptions{
+		Filter:   p.settings.Filter(entry.Name()),
+		MaxPages: entry.MaxPages,
+		Known:    isStored,
+	})
+	if fetchErr != nil {
+		if len(items) == 0 {
+			return 0, fetchErr
+		}
+		// 途中のページで失敗しても、取得できた記事は保存・通知する。
+		// エラーは最後に返し、タスクの失敗としてステータスに記録する
+		p.logger.Warn("一部の取得に失敗しました。取得できた記事は保存します",
+			zap.String("site", entry.Name()), zap.Int("件数", len(items)), zap.Error(fetchErr))
+	}
+
+	saved := saveNewItems(p.logger, items, entry.MaxNew)
+	if len(saved) == 0 {
+		return 0, fetchErr
+	}
+	p.logger.Debug("新着記事を検出しました", zap.String("site", entry.Name()), zap.Int("件数", len(saved)))
+	p.linkTickers(ctx, saved)
+	p.fetchBodies(ctx, saved)
+
+	p.notifyItems(ctx, saved, entry.UrgentOnly)
+	// 購読は通知対象の絞り込みに関係なく、保存したすべての記事と照合する
+	p.notifySubscribers(ctx, saved)
+	return len(saved), fetchErr
+}
+
+// fetchBodies は速報のみその場で本文を取得し、それ以外は本文取得ワーカーに任せます。
+// 速報の取得に失敗または時間切れになった場合も、ワーカー側で再試行されます。
+func (p *pipeline) fetchBodies(ctx context.Context, saved []savedArticle) {
+	if p.bodies == nil {
+		return
+	}
+	timeout := viper.GetDuration("body_fetch.urgent_timeout")
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	for idx := range saved {
+		if !saved[idx].Urgent {
+			if !p.bodies.Enqueue(saved[idx].Article.ID) {
+				p.logger.Debug("本文取得キューが満杯のため後で取得します", zap.Uint("article_id", saved[idx].Article.ID))
+			}
+			continue
+		}
+		fetchCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := p.bodies.FetchNow(fetchCtx, saved[idx].Article)
+		cancel()
+		if err != nil {
+			p.logger.Warn("速報の本文取得に失敗しました", zap.String("url", saved[idx].URL), zap.Error(err))
+			continue
+		}
+		saved[idx].Body = saved[idx].Article.Body
+	}
+}
+
+// saveNewItems は未保存の記事のみをDBに保存し、保存できたものを返します。
+// limit が正の場合は保存件数をその数までに制限します。
+func saveNewItems(logger *zap.Logger, items []sources.Item, limit int) []savedArticle {
+	errMutex.Lock()
+	defer errMutex.Unlock()
+
+	saved := make([]savedArticle, 0, len(items))
+	for _, item := range items {
+		if limit > 0 && len(saved) >= limit {
+			logger.Debug("最大取得数に達したため処理を停止",
+				zap.String("site", item.Site),
+				zap.Int("max_articles", limit))
+			break
+		}
+
+		article, err := saveItem(item)
+		if errors.Is(err, errAlreadyStored) {
+			logger.Debug("すでに存在する記事、スキップ", zap.String("title", item.Title))
+			continue
+		}
+		if err != nil {
+			logger.Error("記事保存失敗", zap.String("site", item.Site), zap.String("title", item.Title), zap.Error(err))
+			continue
+		}
+		saved = append(saved, savedArticle{Item: item, Article: article})
+	}
+	return saved
+}
+
+// linkTickers は保存した記事から銘柄コードを抽出して関連付けます。
+func (p *pipeline) linkTickers(ctx context.Context, saved []savedArticle) {
+	if p.tickers == nil {
+		return
+	}
+	for idx := range saved {
+		codes, err := p.tickers.ExtractAndStore(ctx, saved[idx].Article)
+		if err != nil {
+			p.logger.Warn("銘柄の抽出に失敗しました", zap.String("url", saved[idx].URL), zap.Error(err))
+		}
+		saved[idx].Codes = codes
+	}
+}
+
+// errAlreadyStored は同じURLまたはハッシュの記事が保存済みの場合に返されます。
+var errAlreadyStored = errors.New("記事は保存済みです")
+
+// isStored は同じURLまたはハッシュの記事が保存済みかどうかを返します。
+func isStored(item sources.Item) bool {
+	var exist models.Article
+	return db.Select("id").Where("url = ? OR hash = ?", item.URL, itemHash(item)).First(&exist).Error == nil
+}
+
+func saveItem(item sources.Item) (*models.Article, error) {
+	if isStored(item) {
+		return nil, errAlreadyStored
+	}
+	hash := itemHash(item)
+	article := &models.Article{
+		Site:        item.Site,
+		Title:       item.Title,
+		URL:         item.URL,
+		Hash:        hash,
+		Content:     itemContent(item),
+		Category:    item.Category,
+		StockCode:   item.StockCode,
+		PublishedAt: item.PublishedAt,
+	}
+	if err := db.Create(article).Error; err != nil {
+		return nil, err
+	}
+	return article, nil
+}
+
+func itemContent(item sources.Item) string {
+	if item.Site == "ir" {
+		return fmt.Sprintf("IRカテゴリ: %s", item.Category)
+	}
+	return fmt.Sprintf("カテゴリ: %s", item.Category)
+}
+
+// itemHash は既存の行と重複判定が一致するよう、サイトごとに従来と同じ方式でハッシュを計算します。
+func itemHash(item sources.Item) string {
+	if item.Site == "traders" {
+		return generateHashs(item.Title, item.URL)
+	}
+	return generateHash(item.Title, item.URL, item.URL)
+}
+
+// notifyItems は記事ごとにルーティングのルールを評価し、一致した送信先へ通知を送信待ちに追加します。
+// urgentOnly はソースの設定で、ルールの条件 urgent_only として評価します。
+func (p *pipeline) notifyItems(ctx context.Context, saved []savedArticle, urgentOnly bool) {
+	for _, sa := range saved {
+		routes := p.router.Routes(routing.Article{
+			Site:       sa.Site,
+			Category:   sa.Category,
+			Title:      sa.Title,
+			Body:       sa.Body,
+			StockCode:  sa.StockCode,
+			Codes:      sa.Codes,
+			Urgent:     sa.Urgent,
+			UrgentOnly: urgentOnly,
+		})
+		if len(routes) == 0 {
+			p.logger.Debug("一致するルーティングのルールがありません", zap.String("url", sa.URL))
+			continue
+		}
+		for _, route := range routes {
+			embed, link := articleEmbed(route.Template, sa.Item, route.Color)
+			payload := services.OutboundPayload{
+				Embeds:       []*discordgo.MessageEmbed{embed},
+				Links:        []services.OutboundLink{link},
+				MentionRoles: route.MentionRoles,
+			}
+			if len(route.MentionRoles) > 0 {
+				mentions := make([]string, len(route.MentionRoles))
+				for idx, role := range route.MentionRoles {
+					mentions[idx] = "<@&" + role + ">"
+				}
+				payload.Content = strings.Join(mentions, " ")
+			}
+			if err := p.notifier.Enqueue(ctx, route.ChannelID, payload); err != nil {
+				p.logger.Error("通知の追加に失敗しました",
+					zap.String("rule", route.Rule),
+					zap.String("channel_id", route.ChannelID),
+					zap.String("url", sa.URL),
+					zap.Error(err))
+			}
+		}
+	}
+}
+
+// notifySubscribers は記事に一致した購読者へ DM またはチャンネルのメンションで通知します。
+func (p *pipeline) notifySubscribers(ctx context.Context, saved []savedArticle) {
+	if p.subscriptions == nil {
+		return
+	}
+	for _, sa := range saved {
+		item := sa.Item
+		matches := p.subscriptions.Match(item.Title+"\n"+item.Category, sa.Codes...)
+		if len(matches) == 0 {
+			continue
+		}
+
+		dm := make(map[string][]string)
+		channels := make(map[string]map[string][]string)
+		for _, sub := range matches {
+			if sub.Delivery == models.DeliveryChannel {
+				channelID := viper.GetString("discord.subscription_channel")
+				if channelID == "" {
+					channelID = sub.ChannelID
+				}
+				if channels[channelID] == nil {
+					channels[channelID] = make(map[string][]string)
+				}
+				channels[channelID][sub.UserID] = append(channels[channelID][sub.UserID], sub.Pattern)
+				continue
+			}
+			dm[sub.UserID] = append(dm[sub.UserID], sub.Pattern)
+		}
+
+		for userID, patterns := range dm {
+			if err := p.notifier.EnqueueDM(ctx, userID, services.OutboundPayload{
+				Embeds: []*discordgo.MessageEmbed{subscriptionEmbed(sa, patterns)},
+			}); err != nil {
+				p.logger.Warn("購読通知のDM送信に失敗しました", zap.String("user_id", userID), zap.Error(err))
+			}
+		}
+
+		for channelID, users := range channels {
+			var (
+				mentions []string
+				userIDs  []string
+				patterns []string
+			)
+			for userID, ps := range users {
+				mentions = append(mentions, "<@"+userID+">")
+				userIDs = append(userIDs, userID)
+				patterns = append(patterns, ps...)
+			}
+			if err := p.notifier.Enqueue(ctx, channelID, services.OutboundPayload{
+				Content:      strings.Join(mentions, " "),
+				Embeds:       []*discordgo.MessageEmbed{subscriptionEmbed(sa, patterns)},
+				MentionUsers: userIDs,
+			}); err != nil {
+				p.logger.Warn("購読通知の送信に失敗しました", zap.String("channel_id", channelID), zap.Error(err))
+			}
+		}
+	}
+}
+
+func subscriptionEmbed(sa savedArticle, patterns []string) *discordgo.MessageEmbed {
+	item := sa.Item
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "一致した条件", Value: "`" + strings.Join(patterns, "`, `") + "`", Inline: true},
+	}
+	if item.Category != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "カテゴリ", Value: item.Category, Inline: true})
+	}
+	if len(sa.Codes) > 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "銘柄コード", Value: strings.Join(sa.Codes, ", "), Inline: true})
+	}
+	return &discordgo.MessageEmbed{
+		Author:    &discordgo.MessageEmbedAuthor{Name: "🔔 購読通知"},
+		Title:     truncateString(item.Title, 250),
+		URL:       item.URL,
+		Color:     0x2ECC71,
+		Fields:    fields,
+		Timestamp: item.PublishedAt.Format(time.RFC3339),
+		Footer:    &discordgo.MessageEmbedFooter{Text: "/subscribe list で購読を確認できます"},
+	}
+}
@@ -0,0 +1,234 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// 通知の見た目のテンプレート名
+const (
+	TemplateNews    = "news"
+	TemplateUrgent  = "urgent"
+	TemplateTraders = "traders"
+)
+
+var templates = map[string]bool{TemplateNews: true, TemplateUrgent: true, TemplateTraders: true}
+
+// builtinChannels は既定のルールが使うキー名です。設定ファイルになくても使え、値が空の場合は alert_channel に送ります。
+var builtinChannels = map[string]bool{"alert_channel": true, "urgent_channel": true}
+
+// Rule は条件に一致した記事の送信先と見た目を決めるルールです。
+type Rule struct {
+	Name string `mapstructure:"name"`
+	// When は "category in [決算, 決算修正] and title matches 上方修正" のような条件式。空の場合はすべての記事に一致する
+	When string `mapstructure:"when"`
+	// Channels はチャンネルIDまたは discord セクションのキー名（alert_channel など）。
+	// discord セクションにないキー名は打ち間違いとして読み込み時にエラーにする
+	Channels     []string `mapstructure:"channels"`
+	Color        int      `mapstructure:"color"`
+	MentionRoles []string `mapstructure:"mention_roles"`
+	Template     string   `mapstructure:"template"`
+	// Stop が true の場合、一致したらそれ以降のルールを評価しない
+	Stop bool `mapstructure:"stop"`
+
+	conds []condition
+}
+
+// Route は1つの記事を1つのチャンネルへ送るための情報です。
+type Route struct {
+	Rule         string
+	ChannelID    string
+	Template     string
+	Color        int
+	MentionRoles []string
+}
+
+// DefaultRules はルールファイルがない場合のルールです。
+// 速報は urgent_channel、それ以外は alert_channel に送ります。urgent_only のソースの速報以外は送りません。
+func DefaultRules() []Rule {
+	return []Rule{
+		{Name: "urgent", When: "urgent == true", Channels: []string{"urgent_channel"}, Template: TemplateUrgent, Stop: true},
+		{Name: "traders", When: "site == traders", Channels: []string{"alert_channel"}, Template: TemplateTraders, Stop: true},
+		{Name: "news", When: "urgent_only == false", Channels: []string{"alert_channel"}, Template: TemplateNews},
+	}
+}
+
+// Router は記事ごとにルールを評価し、送信先を決めます。
+// ルールファイルは変更を監視し、読み込みに失敗した場合は直前のルールを使い続けます。
+type Router struct {
+	logger *zap.Logger
+	// watched は銘柄コードのいずれかがウォッチリストに登録されているかを返す（stock_code in watchlist 用）
+	watched func(codes []string) bool
+
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+func NewRouter(logger *zap.Logger, watched func(codes []string) bool) *Router {
+	r := &Router{logger: logger, watched: watched}
+	if err := r.Load(DefaultRules()); err != nil {
+		// 既定のルールは常に正しいため、ここには来ない
+		panic(err)
+	}
+	return r
+}
+
+// Load はルールを検証し、すべて正しい場合のみ差し替えます。
+func (r *Router) Load(rules []Rule) error {
+	compiled := make([]Rule, 0, len(rules))
+	for idx, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%d", idx+1)
+		}
+		conds, err := parseWhen(rule.When)
+		if err != nil {
+			return fmt.Errorf("ルール %s: %w", rule.Name, err)
+		}
+		if len(rule.Channels) == 0 {
+			return fmt.Errorf("ルール %s: channels がありません", rule.Name)
+		}
+		for _, ch := range rule.Channels {
+			if !knownChannel(ch) {
+				return fmt.Errorf("ルール %s: 不明なチャンネルです: %q (チャンネルID、または discord セクションのキー名)", rule.Name, ch)
+			}
+		}
+		if rule.Template == "" {
+			rule.Template = TemplateNews
+		}
+		if !templates[rule.Template] {
+			return fmt.Errorf("ルール %s: 不明なテンプレートです: %q (news, urgent, traders のいずれか)", rule.Name, rule.Template)
+		}
+		rule.conds = conds
+		compiled = append(compiled, rule)
+	}
+
+	r.mu.Lock()
+	r.rules = compiled
+	r.mu.Unlock()
+	return nil
+}
+
+// LoadFile はルールファイルを読み込み、変更の監視を始めます。
+// ファイルがない場合は既定のルールのまま nil を返します。
+func (r *Router) LoadFile(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		r.logger.Info("ルーティングの設定ファイルがないため既定のルールを使います", zap.String("file", path))
+		return nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("ルーティングの設定ファイルの読み込みに失敗しました: %w", err)
+	}
+	if err := r.loadFrom(v); err != nil {
+		return err
+	}
+
+	v.OnConfigChange(func(e fsnotify.Event) {
+		if err := r.loadFrom(v); err != nil {
+			r.logger.Error("ルーティングの再読み込みに失敗したため、直前のルールを使い続けます",
+				zap.String("file", e.Name), zap.Error(err))
+			return
+		}
+		r.logger.Info("ルーティングを再読み込みしました", zap.String("file", e.Name), zap.Int("rules", len(r.Rules())))
+	})
+	v.WatchConfig()
+	return nil
+}
+
+func (r *Router) loadFrom(v *viper.Viper) error {
+	var rules []Rule
+	if err := v.UnmarshalKey("rules", &rules); err != nil {
+		return fmt.Errorf("ルールの形式が不正です: %w", err)
+	}
+	if len(rules) == 0 {
+		return errors.New("rules が空です")
+	}
+	return r.Load(rules)
+}
+
+// Rules は現在のルールの一覧を返します。
+func (r *Router) Rules() []Rule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Rule(nil), r.rules...)
+}
+
+// Routes は記事に一致したルールの送信先を返します。
+// 同じチャンネルに複数のルールが一致した場合は、先に一致したルールだけを使います。
+func (r *Router) Routes(a Article) []Route {
+	var routes []Route
+	seen := make(map[string]bool)
+	for _, rule := range r.Rules() {
+		if !rule.match(a, r.watched) {
+			continue
+		}
+		for _, ch := range rule.Channels {
+			channelID := resolveChannel(ch)
+			if channelID == "" {
+				r.logger.Warn("送信先のチャンネルが設定されていません", zap.String("rule", rule.Name), zap.String("channel", ch))
+				continue
+			}
+			if seen[channelID] {
+				continue
+			}
+			seen[channelID] = true
+			routes = append(routes, Route{
+				Rule:         rule.Name,
+				ChannelID:    channelID,
+				Template:     rule.Template,
+				Color:        rule.Color,
+				MentionRoles: rule.MentionRoles,
+			})
+		}
+		if rule.Stop {
+			break
+		}
+	}
+	return routes
+}
+
+func (rule Rule) match(a Article, watched func(codes []string) bool) bool {
+	for _, c := range rule.conds {
+		if !c.match(a, watched) {
+			return false
+		}
+	}
+	return true
+}
+
+// knownChannel はチャンネルIDか、discord セクションにあるキー名かどうかを返します。
+func knownChannel(ch string) bool {
+	return isID(ch) || builtinChannels[ch] || viper.IsSet("discord."+ch)
+}
+
+// resolveChannel はチャンネルIDはそのまま、キー名は discord セクションの値に置き換えます。
+// キーの値が空の場合は alert_channel に送ります。キー名が存在することは Load で確認済みです。
+func resolveChannel(ch string) string {
+	if isID(ch) {
+		return ch
+	}
+	if id := viper.GetString("discord." + ch); id != "" {
+		return id
+	}
+	return viper.GetString("discord.alert_channel")
+}
+
+func isID(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
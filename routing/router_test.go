@@ -0,0 +1,159 @@
+package routing
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// setChannels は discord セクションのチャンネルを設定し、テスト後に元に戻します。
+func setChannels(t *testing.T, channels map[string]string) {
+	t.Helper()
+	viper.Reset()
+	for key, id := range channels {
+		viper.Set("discord."+key, id)
+	}
+	t.Cleanup(viper.Reset)
+}
+
+func TestRoutes(t *testing.T) {
+	setChannels(t, map[string]string{"alert_channel": "100", "urgent_channel": "", "earnings_channel": "300"})
+	r := NewRouter(zap.NewNop(), nil)
+	err := r.Load([]Rule{
+		{Name: "earnings", When: "category == 決算", Channels: []string{"earnings_channel", "400"}, Color: 0xFF4500, MentionRoles: []string{"9"}},
+		{Name: "urgent", When: "urgent == true", Channels: []string{"urgent_channel"}, Template: TemplateUrgent, Stop: true},
+		{Name: "news", Channels: []string{"alert_channel"}},
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	got := r.Routes(Article{Category: "決算"})
+	want := []Route{
+		{Rule: "earnings", ChannelID: "300", Template: TemplateNews, Color: 0xFF4500, MentionRoles: []string{"9"}},
+		{Rule: "earnings", ChannelID: "400", Template: TemplateNews, Color: 0xFF4500, MentionRoles: []string{"9"}},
+		{Rule: "news", ChannelID: "100", Template: TemplateNews},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Routes(決算) = %+v\nwant %+v", got, want)
+	}
+
+	// urgent_channel が空の場合は alert_channel に送り、stop で news は評価しない
+	got = r.Routes(Article{Urgent: true})
+	want = []Route{{Rule: "urgent", ChannelID: "100", Template: TemplateUrgent}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Routes(urgent) = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestLoadRejectsUnknownChannel(t *testing.T) {
+	setChannels(t, map[string]string{"alert_channel": "100"})
+	r := NewRouter(zap.NewNop(), nil)
+	before := r.Rules()
+
+	err := r.Load([]Rule{{Name: "earnings", Channels: []string{"alert_chanel"}}})
+	if err == nil || !strings.Contains(err.Error(), "alert_chanel") {
+		t.Fatalf("Load with a mistyped channel: err = %v", err)
+	}
+	if !reflect.DeepEqual(r.Rules(), before) {
+		t.Error("rules replaced by an invalid load")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	setChannels(t, map[string]string{"alert_channel": "100"})
+	tests := []struct {
+		rule Rule
+		want string
+	}{
+		{Rule{Name: "empty"}, "channels がありません"},
+		{Rule{Name: "when", When: "site = ir", Channels: []string{"100"}}, "条件の形式が不正です"},
+		{Rule{Name: "template", Channels: []string{"100"}, Template: "card"}, "不明なテンプレートです"},
+	}
+	for _, tt := range tests {
+		err := NewRouter(zap.NewNop(), nil).Load([]Rule{tt.rule})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Load(%s): err = %v, want %q", tt.rule.Name, err, tt.want)
+		}
+	}
+}
+
+func TestDefaultRulesWithoutUrgentChannel(t *testing.T) {
+	// urgent_channel を設定していない既存の設定ファイルでも既定のルールを使える
+	setChannels(t, map[string]string{"alert_channel": "100"})
+	r := NewRouter(zap.NewNop(), nil)
+	if got := r.Routes(Article{Urgent: true}); len(got) != 1 || got[0].ChannelID != "100" {
+		t.Errorf("Routes(urgent) = %+v, want alert_channel", got)
+	}
+}
+
+func TestDefaultRulesUrgentOnly(t *testing.T) {
+	setChannels(t, map[string]string{"alert_channel": "100", "urgent_channel": "200"})
+	r := NewRouter(zap.NewNop(), nil)
+	// urgent_only のソースは速報のみを送り、速報以外は既定のルールに一致しない
+	if got := r.Routes(Article{Site: "ir", UrgentOnly: true, Urgent: true}); len(got) != 1 || got[0].ChannelID != "200" {
+		t.Errorf("Routes(urgent ir) = %+v, want urgent_channel", got)
+	}
+	if got := r.Routes(Article{Site: "ir", UrgentOnly: true}); len(got) != 0 {
+		t.Errorf("Routes(ir) = %+v, want none", got)
+	}
+	if got := r.Routes(Article{Site: "kabutan"}); len(got) != 1 || got[0].ChannelID != "100" {
+		t.Errorf("Routes(kabutan) = %+v, want alert_channel", got)
+	}
+}
+
+func TestUserRuleToAlertChannelIgnoresUrgentOnly(t *testing.T) {
+	setChannels(t, map[string]string{"alert_channel": "100", "urgent_channel": "200"})
+	rules := append([]Rule{{Name: "earnings", When: "category == 決算", Channels: []string{"alert_channel"}, Color: 0xFF4500}}, DefaultRules()...)
+	r := NewRouter(zap.NewNop(), nil)
+	if err := r.Load(rules); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	// urgent_only を条件にしないルールは、送信先が alert_channel でも urgent_only のソースの記事を送る
+	got := r.Routes(Article{Site: "ir", Category: "決算", UrgentOnly: true})
+	want := []Route{{Rule: "earnings", ChannelID: "100", Template: TemplateNews, Color: 0xFF4500}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Routes(ir 決算) = %+v\nwant %+v", got, want)
+	}
+	if got := r.Routes(Article{Site: "ir", Category: "開示", UrgentOnly: true}); len(got) != 0 {
+		t.Errorf("Routes(ir 開示) = %+v, want none", got)
+	}
+}
+
+func TestLoadFileKeepsRulesOnInvalidReload(t *testing.T) {
+	setChannels(t, map[string]string{"alert_channel": "100", "ir_channel": "200"})
+	path := filepath.Join(t.TempDir(), "routing.yaml")
+	write := func(body string) {
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("rules:\n  - name: ir\n    when: site == ir\n    channels: [ir_channel]\n")
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRouter(zap.NewNop(), nil)
+	if err := r.loadFrom(v); err != nil {
+		t.Fatalf("loadFrom: %v", err)
+	}
+
+	// 再読み込みで打ち間違えたキー名は拒否し、直前のルールを使い続ける
+	write("rules:\n  - name: ir\n    when: site == ir\n    channels: [ir_chanel]\n")
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.loadFrom(v); err == nil {
+		t.Fatal("reload with a mistyped channel succeeded")
+	}
+	if got := r.Routes(Article{Site: "ir"}); len(got) != 1 || got[0].ChannelID != "200" {
+		t.Errorf("Routes after invalid reload = %+v, want the previous ir rule", got)
+	}
+}
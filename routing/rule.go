@@ -0,0 +1,186 @@
+package routing
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"bot/matcher"
+)
+
+// Article はルールの評価に使う記事の項目です。
+type Article struct {
+	Site      string
+	Category  string
+	Title     string
+	Body      string
+	StockCode string
+	// Codes は記事から抽出した銘柄コード。stock_code の条件は StockCode と Codes のいずれかが一致すれば真
+	Codes  []string
+	Urgent bool
+	// UrgentOnly は取得元のソースが速報のみを通知する設定（urgent_only）かどうか。
+	// 既定のルールは urgent_only == false で、そのソースの速報以外を流さない
+	UrgentOnly bool
+}
+
+func (a Article) codes() []string {
+	if a.StockCode == "" {
+		return a.Codes
+	}
+	for _, c := range a.Codes {
+		if c == a.StockCode {
+			return a.Codes
+		}
+	}
+	return append([]string{a.StockCode}, a.Codes...)
+}
+
+func (a Article) field(name string) string {
+	switch name {
+	case "site":
+		return a.Site
+	case "category":
+		return a.Category
+	case "title":
+		return a.Title
+	case "body":
+		return a.Body
+	case "urgent":
+		return strconv.FormatBool(a.Urgent)
+	case "urgent_only":
+		return strconv.FormatBool(a.UrgentOnly)
+	}
+	return ""
+}
+
+var fields = map[string]bool{
+	"site": true, "category": true, "title": true, "body": true, "stock_code": true, "urgent": true, "urgent_only": true,
+}
+
+// condRE は "フィールド 演算子 値" の形の条件です。
+var condRE = regexp.MustCompile(`^([a-z_]+)\s+(==|!=|in|matches|contains)\s+(.+)$`)
+
+// condition は when に書かれた条件の1つです。
+type condition struct {
+	field     string
+	op        string
+	values    []string
+	re        *regexp.Regexp
+	watchlist bool
+}
+
+// parseWhen は "category in [決算, 決算修正] and title matches 上方修正" のような条件式を解析します。
+// 条件は and でつなぎ、すべて満たした場合に一致します。空文字列は常に一致します。
+func parseWhen(when string) ([]condition, error) {
+	when = strings.TrimSpace(when)
+	if when == "" {
+		return nil, nil
+	}
+	var conds []condition
+	for _, part := range strings.Split(when, " and ") {
+		c, err := parseCondition(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, c)
+	}
+	return conds, nil
+}
+
+func parseCondition(s string) (condition, error) {
+	m := condRE.FindStringSubmatch(s)
+	if m == nil {
+		return condition{}, fmt.Errorf("条件の形式が不正です: %q (例: category in [決算, 決算修正])", s)
+	}
+	c := condition{field: m[1], op: m[2]}
+	if !fields[c.field] {
+		return condition{}, fmt.Errorf("不明な項目です: %q (site, category, title, body, stock_code, urgent, urgent_only のいずれか)", c.field)
+	}
+	value := strings.TrimSpace(m[3])
+
+	switch c.op {
+	case "in":
+		if value == "watchlist" {
+			if c.field != "stock_code" {
+				return condition{}, fmt.Errorf("in watchlist は stock_code にのみ使えます: %q", s)
+			}
+			c.watchlist = true
+			return c, nil
+		}
+		if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+			return condition{}, fmt.Errorf("in の値は [a, b] の形で指定してください: %q", s)
+		}
+		for _, v := range strings.Split(value[1:len(value)-1], ",") {
+			if v = unquote(v); v != "" {
+				c.values = append(c.values, v)
+			}
+		}
+	case "matches":
+		re, err := regexp.Compile(unquote(value))
+		if err != nil {
+			return condition{}, fmt.Errorf("正規表現が不正です: %q: %w", s, err)
+		}
+		c.re = re
+	default:
+		c.values = []string{unquote(value)}
+	}
+	return c, nil
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// match は記事が条件を満たすかどうかを返します。watched は in watchlist の判定に使います。
+func (c condition) match(a Article, watched func(codes []string) bool) bool {
+	if c.field == "stock_code" {
+		codes := a.codes()
+		if c.watchlist {
+			return len(codes) > 0 && watched != nil && watched(codes)
+		}
+		if c.op == "!=" {
+			for _, code := range codes {
+				if code == c.values[0] {
+					return false
+				}
+			}
+			return true
+		}
+		for _, code := range codes {
+			if c.matchValue(code) {
+				return true
+			}
+		}
+		return false
+	}
+	v := a.field(c.field)
+	if c.op == "!=" {
+		return v != c.values[0]
+	}
+	return c.matchValue(v)
+}
+
+func (c condition) matchValue(v string) bool {
+	switch c.op {
+	case "==":
+		return v == c.values[0]
+	case "in":
+		for _, want := range c.values {
+			if v == want {
+				return true
+			}
+		}
+		return false
+	case "matches":
+		return c.re.MatchString(v)
+	case "contains":
+		// 全角・半角や大文字・小文字の違いは購読と同じく無視する
+		return strings.Contains(matcher.Normalize(v), matcher.Normalize(c.values[0]))
+	}
+	return false
+}
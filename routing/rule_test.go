@@ -0,0 +1,148 @@
+package routing
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWhen(t *testing.T) {
+	tests := []struct {
+		when string
+		want []condition
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"site == ir", []condition{{field: "site", op: "==", values: []string{"ir"}}}},
+		{`category != "決算"`, []condition{{field: "category", op: "!=", values: []string{"決算"}}}},
+		{"category in [決算, '決算修正', ]", []condition{{field: "category", op: "in", values: []string{"決算", "決算修正"}}}},
+		{"stock_code in watchlist", []condition{{field: "stock_code", op: "in", watchlist: true}}},
+		{"title contains ＥＶ", []condition{{field: "title", op: "contains", values: []string{"ＥＶ"}}}},
+		{
+			"site == kabutan and urgent == true and stock_code in [7203, 6758]",
+			[]condition{
+				{field: "site", op: "==", values: []string{"kabutan"}},
+				{field: "urgent", op: "==", values: []string{"true"}},
+				{field: "stock_code", op: "in", values: []string{"7203", "6758"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseWhen(tt.when)
+		if err != nil {
+			t.Errorf("parseWhen(%q): %v", tt.when, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseWhen(%q) = %+v, want %+v", tt.when, got, tt.want)
+		}
+	}
+}
+
+func TestParseWhenMatches(t *testing.T) {
+	conds, err := parseWhen(`title matches "上方修正|増配"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conds) != 1 || conds[0].re == nil || conds[0].re.String() != "上方修正|増配" {
+		t.Fatalf("parseWhen = %+v", conds)
+	}
+}
+
+func TestParseWhenErrors(t *testing.T) {
+	tests := []struct {
+		when string
+		want string
+	}{
+		{"site", "条件の形式が不正です"},
+		{"site = ir", "条件の形式が不正です"},
+		{"site == ir and and urgent == true", "条件の形式が不正です"},
+		{"author == foo", "不明な項目です"},
+		{"category in watchlist", "in watchlist は stock_code にのみ使えます"},
+		{"category in 決算", "in の値は [a, b] の形で指定してください"},
+		{"title matches (上方", "正規表現が不正です"},
+	}
+	for _, tt := range tests {
+		_, err := parseWhen(tt.when)
+		if err == nil {
+			t.Errorf("parseWhen(%q): expected error", tt.when)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseWhen(%q) = %v, want %q", tt.when, err, tt.want)
+		}
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	article := Article{
+		Site:      "ir",
+		Category:  "決算修正",
+		Title:     "業績予想の上方修正に関するお知らせ",
+		Body:      "ＥＶ関連の販売が好調",
+		StockCode: "7203",
+		Codes:     []string{"6758"},
+	}
+	watched := func(codes []string) bool {
+		for _, c := range codes {
+			if c == "6758" {
+				return true
+			}
+		}
+		return false
+	}
+	tests := []struct {
+		when string
+		want bool
+	}{
+		{"", true},
+		{"site == ir", true},
+		{"site == kabutan", false},
+		{"site != kabutan", true},
+		{"category in [決算, 決算修正]", true},
+		{"category in [決算]", false},
+		{"title matches 上方修正", true},
+		{"title matches ^上方修正", false},
+		{"body contains ev関連", true},
+		{"urgent == false", true},
+		{"urgent == true", false},
+		{"urgent_only == false", true},
+		{"urgent_only == true", false},
+		// StockCode と Codes のどちらかが一致すれば真
+		{"stock_code == 7203", true},
+		{"stock_code == 6758", true},
+		{"stock_code in [1301, 6758]", true},
+		{"stock_code == 9984", false},
+		// != はどの銘柄コードとも一致しない場合に真
+		{"stock_code != 6758", false},
+		{"stock_code != 9984", true},
+		{"stock_code in watchlist", true},
+		{"site == ir and title matches 上方修正", true},
+		{"site == ir and title matches 下方修正", false},
+	}
+	for _, tt := range tests {
+		conds, err := parseWhen(tt.when)
+		if err != nil {
+			t.Fatalf("parseWhen(%q): %v", tt.when, err)
+		}
+		rule := Rule{conds: conds}
+		if got := rule.match(article, watched); got != tt.want {
+			t.Errorf("%q matched = %v, want %v", tt.when, got, tt.want)
+		}
+	}
+}
+
+func TestStockCodeWithoutCodes(t *testing.T) {
+	conds, err := parseWhen("stock_code in watchlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := Rule{conds: conds}
+	// 銘柄コードのない記事は in watchlist に一致しない
+	if rule.match(Article{Site: "kabutan"}, func([]string) bool { return true }) {
+		t.Error("article without codes matched in watchlist")
+	}
+	if rule.match(Article{StockCode: "7203"}, nil) {
+		t.Error("matched in watchlist without a watchlist")
+	}
+}
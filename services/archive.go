@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"bot/models"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ftsMinChars は trigram 索引で検索できる最小文字数です。これより短い語は LIKE で絞り込みます。
+const ftsMinChars = 3
+
+// SearchQuery は /archive search の検索条件です。ゼロ値の項目は条件に含めません。
+type SearchQuery struct {
+	Keywords  string
+	From      time.Time
+	To        time.Time // この時刻を含まない
+	Site      string
+	Category  string
+	StockCode string
+}
+
+// SearchResult は1ページ分の検索結果です。
+type SearchResult struct {
+	Articles []models.Article
+	Total    int64
+	Page     int
+	Pages    int
+}
+
+// ArchiveService は保存済み記事の検索を提供します。
+type ArchiveService struct {
+	db     *gorm.DB
+	logger *zap.Logger
+	fts    bool
+}
+
+func NewArchiveService(db *gorm.DB, logger *zap.Logger) *ArchiveService {
+	return &ArchiveService{
+		db:     db,
+		logger: logger,
+		fts:    db.Dialector.Name() == "sqlite" && db.Migrator().HasTable("articles_fts"),
+	}
+}
+
+// Search は条件に一致する記事を新しい順に返します。page は1始まりで、範囲外の場合は丸めます。
+func (a *ArchiveService) Search(ctx context.Context, q SearchQuery, page, perPage int) (*SearchResult, error) {
+	tx := a.build(ctx, q)
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("検索件数の取得に失敗しました: %w", err)
+	}
+
+	pages := int((total + int64(perPage) - 1) / int64(perPage))
+	if page > pages {
+		page = pages
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	var articles []models.Article
+	if err := tx.Order("articles.published_at DESC").
+		Offset((page - 1) * perPage).
+		Limit(perPage).
+		Find(&articles).Error; err != nil {
+		return nil, fmt.Errorf("記事の検索に失敗しました: %w", err)
+	}
+
+	return &SearchResult{Articles: articles, Total: total, Page: page, Pages: pages}, nil
+}
+
+func (a *ArchiveService) build(ctx context.Context, q SearchQuery) *gorm.DB {
+	tx := a.db.WithContext(ctx).Model(&models.Article{})
+
+	var ftsTerms []string
+	for _, term := range strings.Fields(q.Keywords) {
+		if a.fts && utf8.RuneCountInString(term) >= ftsMinChars {
+			// フレーズとして扱い、FTS5 の演算子として解釈されないようにする
+			ftsTerms = append(ftsTerms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+			continue
+		}
+		like := "%" + escapeLike(term) + "%"
+		tx = tx.Where(`(articles.title LIKE ? ESCAPE '\' OR articles.category LIKE ? ESCAPE '\' OR articles.body LIKE ? ESCAPE '\' OR articles.summary LIKE ? ESCAPE '\')`,
+			like, like, like, like)
+	}
+	if len(ftsTerms) > 0 {
+		tx = tx.Where("articles.id IN (SELECT rowid FROM articles_fts WHERE articles_fts MATCH ?)", strings.Join(ftsTerms, " AND "))
+	}
+
+	if !q.From.IsZero() {
+		tx = tx.Where("articles.published_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("articles.published_at < ?", q.To)
+	}
+	if q.Site != "" {
+		tx = tx.Where("articles.site = ?", q.Site)
+	}
+	if q.Category != "" {
+		tx = tx.Where("articles.category LIKE ? ESCAPE '\\'", "%"+escapeLike(q.Category)+"%")
+	}
+	if q.StockCode != "" {
+		tx = tx.Where("articles.id IN (SELECT article_id FROM article_tickers WHERE stock_code = ?)", strings.ToUpper(q.StockCode))
+	}
+	return tx
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
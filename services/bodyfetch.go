@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"bot/models"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultBodyWorkers        = 2
+	defaultBodyQueueSize      = 500
+	defaultBodyMaxRetries     = 3
+	defaultBodyDomainInterval = 2 * time.Second
+	// bodyRetryInterval より前に失敗した記事だけを EnqueuePending で再投入する
+	bodyRetryInterval = 5 * time.Minute
+)
+
+// bodySites は本文を取得するサイトです。bodySelectors にセレクタがあるホストの記事に限ります。
+var bodySites = []string{"kabutan", "ir", "traders"}
+
+var errEmptyBody = errors.New("本文が見つかりませんでした")
+
+// BodyFetcher は新着記事のページを開いて本文を取得し、Body と LastScrapedAt を保存します。
+// 失敗した記事は RetryCount を増やし、body_fetch.max_retries 回で諦めます。
+type BodyFetcher struct {
+	db         *gorm.DB
+	logger     *zap.Logger
+	client     *http.Client
+	limiter    *domainLimiter
+	maxRetries int
+	workers    int
+
+	queue chan uint
+
+	mu        sync.Mutex
+	inflight  map[uint]bool
+	onFetched []func(ctx context.Context, article *models.Article)
+
+	wg sync.WaitGroup
+}
+
+func NewBodyFetcher(db *gorm.DB, logger *zap.Logger) *BodyFetcher {
+	timeout := time.Duration(viper.GetInt("scraping.timeout")) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	interval := viper.GetDuration("body_fetch.domain_interval")
+	if interval <= 0 {
+		interval = defaultBodyDomainInterval
+	}
+	maxRetries := viper.GetInt("body_fetch.max_retries")
+	if maxRetries <= 0 {
+		maxRetries = defaultBodyMaxRetries
+	}
+	workers := viper.GetInt("body_fetch.workers")
+	if workers <= 0 {
+		workers = defaultBodyWorkers
+	}
+	queueSize := viper.GetInt("body_fetch.queue_size")
+	if queueSize <= 0 {
+		queueSize = defaultBodyQueueSize
+	}
+
+	return &BodyFetcher{
+		db:         db,
+		logger:     logger,
+		client:     &http.Client{Timeout: timeout},
+		limiter:    newDomainLimiter(interval),
+		maxRetries: maxRetries,
+		workers:    workers,
+		queue:      make(chan uint, queueSize),
+		inflight:   make(map[uint]bool),
+	}
+}
+
+// OnFetched は本文の保存後に呼ばれる処理を登録します。Start の前に呼び出してください。
+func (f *BodyFetcher) OnFetched(fn func(ctx context.Context, article *models.Article)) {
+	f.onFetched = append(f.onFetched, fn)
+}
+
+// Start はワーカーを起動します。ctx がキャンセルされると取得中の記事を終えてから停止します。
+func (f *BodyFetcher) Start(ctx context.Context) {
+	for n := 0; n < f.workers; n++ {
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-f.queue:
+					f.process(ctx, id)
+				}
+			}
+		}()
+	}
+	f.logger.Info("本文取得ワーカーを起動しました", zap.Int("workers", f.workers))
+}
+
+// Wait は Start で起動したワーカーの終了を待ちます。
+func (f *BodyFetcher) Wait() {
+	f.wg.Wait()
+}
+
+// Enqueue は記事を取得待ちに追加します。キューが満杯の場合は追加せず false を返します。
+// 取りこぼした記事は EnqueuePending で拾い直されます。
+func (f *BodyFetcher) Enqueue(id uint) bool {
+	if !f.claim(id) {
+		return true
+	}
+	select {
+	case f.queue <- id:
+		return true
+	default:
+		f.release(id)
+		return false
+	}
+}
+
+// EnqueuePending は本文が未取得で、リトライ上限に達していない記事をキューに追加します。
+func (f *BodyFetcher) EnqueuePending(ctx context.Context) (int, error) {
+	var ids []uint
+	if err := f.db.WithContext(ctx).Model(&models.Article{}).
+		Where("(body = '' OR body IS NULL) AND retry_count < ? AND site IN ?", f.maxRetries, bodySites).
+		Where("last_scraped_at IS NULL OR last_scraped_at < ?", time.Now().Add(-bodyRetryInterval)).
+		Order("id DESC").
+		Limit(cap(f.queue)).
+		Pluck("id", &ids).Error; err != nil {
+		return 0, fmt.Errorf("本文未取得の記事の検索に失敗しました: %w", err)
+	}
+
+	queued := 0
+	for _, id := range ids {
+		if !f.Enqueue(id) {
+			break
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// FetchNow はキューを通さずにその場で本文を取得して保存します。速報のように通知前に本文が必要な場合に使います。
+// ドメインごとの間隔制限はワーカーと共有します。
+func (f *BodyFetcher) FetchNow(ctx context.Context, article *models.Article) error {
+	if !f.claim(article.ID) {
+		return fmt.Errorf("記事 %d は取得中です", article.ID)
+	}
+	defer f.release(article.ID)
+	return f.fetch(ctx, article)
+}
+
+func (f *BodyFetcher) process(ctx context.Context, id uint) {
+	defer f.release(id)
+
+	var article models.Article
+	if err := f.db.WithContext(ctx).First(&article, id).Error; err != nil {
+		f.logger.Warn("本文取得対象の記事を読み込めませんでした", zap.Uint("article_id", id), zap.Error(err))
+		return
+	}
+	if article.Body != "" || article.RetryCount >= f.maxRetries {
+		return
+	}
+	if err := f.fetch(ctx, &article); err != nil && ctx.Err() == nil {
+		f.logger.Warn("本文の取得に失敗しました",
+			zap.Uint("article_id", id),
+			zap.String("url", article.URL),
+			zap.Int("retry_count", article.RetryCount),
+			zap.Error(err))
+	}
+}
+
+func (f *BodyFetcher) fetch(ctx context.Context, article *models.Article) error {
+	u, err := url.Parse(article.URL)
+	if err != nil {
+		return f.fail(ctx, article, err)
+	}
+	if err := f.limiter.Wait(ctx, u.Host); err != nil {
+		return err
+	}
+
+	body, err := FetchArticleBody(ctx, f.client, article.URL)
+	if err == nil && body == "" {
+		err = errEmptyBody
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return f.fail(ctx, article, err)
+	}
+
+	now := time.Now()
+	if err := f.db.WithContext(ctx).Model(&models.Article{}).
+		Where("id = ?", article.ID).
+		Updates(map[string]interface{}{"body": body, "last_scraped_at": now}).Error; err != nil {
+		return fmt.Errorf("本文の保存に失敗しました: %w", err)
+	}
+	article.Body = body
+	article.LastScrapedAt = now
+
+	for _, fn := range f.onFetched {
+		fn(ctx, article)
+	}
+	return nil
+}
+
+// fail は失敗回数を記録します。上限に達した記事は以後取得しません。
+func (f *BodyFetcher) fail(ctx context.Context, article *models.Article, cause error) error {
+	article.LastScrapedAt = time.Now()
+	// ワーカーと FetchNow が同じ記事を扱う場合があるため、DB上の値に加算する
+	err := f.db.WithContext(ctx).Model(&models.Article{}).
+		Where("id = ?", article.ID).
+		Updates(map[string]interface{}{"retry_count": gorm.Expr("retry_count + 1"), "last_scraped_at": article.LastScrapedAt}).Error
+	if err == nil {
+		err = f.db.WithContext(ctx).Model(&models.Article{}).Where("id = ?", article.ID).Pluck("retry_count", &article.RetryCount).Error
+	}
+	if err != nil {
+		f.logger.Error("リトライ回数の保存に失敗しました", zap.Uint("article_id", article.ID), zap.Error(err))
+	}
+	if article.RetryCount >= f.maxRetries {
+		f.logger.Info("本文の取得を諦めました",
+			zap.Uint("article_id", article.ID),
+			zap.String("url", article.URL),
+			zap.Int("retry_count", article.RetryCount))
+	}
+	return cause
+}
+
+func (f *BodyFetcher) claim(id uint) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.inflight[id] {
+		return false
+	}
+	f.inflight[id] = true
+	return true
+}
+
+func (f *BodyFetcher) release(id uint) {
+	f.mu.Lock()
+	delete(f.inflight, id)
+	f.mu.Unlock()
+}
+
+// domainLimiter はホストごとにリクエストの間隔を空けます。
+type domainLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     map[string]time.Time
+}
+
+func newDomainLimiter(interval time.Duration) *domainLimiter {
+	return &domainLimiter{interval: interval, next: make(map[string]time.Time)}
+}
+
+// Wait はホストへの次のリクエストが許可されるまで待ちます。
+func (l *domainLimiter) Wait(ctx context.Context, host string) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[host] = at.Add(l.interval)
+	l.mu.Unlock()
+
+	wait := time.Until(at)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	"bot/config"
+	"bot/database"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// openTestDB は一時ディレクトリにマイグレーション済みの SQLite データベースを作成します。
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := database.Open(config.DatabaseConfig{DSN: filepath.Join(t.TempDir(), "articles.db")}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { database.Close(db) })
+	if err := database.Migrate(db, zap.NewNop()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return db
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"bot/ai"
+	"bot/models"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultMaxInputChars は ai.max_input_chars 未設定時の1リクエストあたりの入力上限
+	defaultMaxInputChars = 24000
+	// maxArticleChars は1記事あたりに含める本文の上限
+	maxArticleChars = 2000
+)
+
+// Digest は定期要約ジョブの結果です。
+type Digest struct {
+	Text     string
+	Articles int
+	Batches  int
+	From     time.Time
+	To       time.Time
+}
+
+type digestResponse struct {
+	Summaries []struct {
+		ID      uint   `json:"id"`
+		Summary string `json:"summary"`
+	} `json:"summaries"`
+	Digest string `json:"digest"`
+}
+
+// RunDigest は since 以降に取得した記事から全体のダイジェストを作り、未要約の記事には記事ごとの要約を保存します。
+// /summary で要約済みの記事もダイジェストの対象に含め、既存の要約はそのまま使います。対象記事がない場合は nil を返します。
+func (s *SummaryService) RunDigest(ctx context.Context, since time.Time) (*Digest, error) {
+	var articles []models.Article
+	if err := s.db.WithContext(ctx).
+		Where("created_at >= ?", since).
+		Order("published_at ASC").
+		Find(&articles).Error; err != nil {
+		return nil, fmt.Errorf("ダイジェスト対象の記事の取得に失敗しました: %w", err)
+	}
+	if len(articles) == 0 {
+		return nil, nil
+	}
+
+	batches := batchArticles(articles, s.maxInputChars())
+	digests := make([]string, 0, len(batches))
+	for idx, batch := range batches {
+		results, err := s.summarizeSplitting(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("バッチ %d/%d の要約に失敗しました: %w", idx+1, len(batches), err)
+		}
+		for _, res := range results {
+			s.storeDigestSummaries(ctx, res)
+			digests = append(digests, strings.TrimSpace(res.Digest))
+		}
+	}
+
+	text := digests[0]
+	if len(digests) > 1 {
+		merged, err := s.mergeDigests(ctx, digests)
+		if err != nil {
+			s.logger.Warn("ダイジェストの統合に失敗したため連結して返します", zap.Error(err))
+			merged = strings.Join(digests, "\n\n")
+		}
+		text = merged
+	}
+
+	return &Digest{
+		Text:     text,
+		Articles: len(articles),
+		Batches:  len(batches),
+		From:     since,
+		To:       time.Now(),
+	}, nil
+}
+
+func (s *SummaryService) maxInputChars() int {
+	if s.cfg.MaxInputChars > 0 {
+		return s.cfg.MaxInputChars
+	}
+	return defaultMaxInputChars
+}
+
+// batchArticles は入力文字数の上限を超えないように記事を分割します。
+func batchArticles(articles []models.Article, limit int) [][]models.Article {
+	var (
+		batches [][]models.Article
+		current []models.Article
+		size    int
+	)
+	for _, a := range articles {
+		n := len([]rune(formatDigestArticle(a)))
+		if len(current) > 0 && size+n > limit {
+			batches = append(batches, current)
+			current, size = nil, 0
+		}
+		current = append(current, a)
+		size += n
+	}
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+	return batches
+}
+
+// formatDigestArticle はプロンプトに埋め込む1記事分のテキストを組み立てます。
+// 要約済みの記事は要約を、本文が未取得の場合はタイトルとカテゴリのみで要約させます。
+func formatDigestArticle(a models.Article) string {
+	body := a.Body
+	if a.Summary != "" {
+		body = a.Summary
+	} else if body == "" {
+		body = a.Content
+	}
+	if r := []rune(body); len(r) > maxArticleChars {
+		body = string(r[:maxArticleChars]) + "…"
+	}
+	return fmt.Sprintf("[ID:%d] カテゴリ: %s / タイトル: %s\n%s\n\n", a.ID, a.Category, a.Title, body)
+}
+
+// summarizeSplitting はコンテキスト長超過で失敗した場合にバッチを半分に分けて再試行します。
+func (s *SummaryService) summarizeSplitting(ctx context.Context, batch []models.Article) ([]*digestResponse, error) {
+	res, err := s.summarizeBatch(ctx, batch)
+	if err == nil {
+		return []*digestResponse{res}, nil
+	}
+	if !errors.Is(err, ai.ErrContextLength) || len(batch) < 2 {
+		return nil, err
+	}
+
+	s.logger.Warn("コンテキスト長を超えたためバッチを分割します", zap.Int("articles", len(batch)))
+	mid := len(batch) / 2
+	left, err := s.summarizeSplitting(ctx, batch[:mid])
+	if err != nil {
+		return nil, err
+	}
+	right, err := s.summarizeSplitting(ctx, batch[mid:])
+	if err != nil {
+		return nil, err
+	}
+	return append(left, right...), nil
+}
+
+func (s *SummaryService) storeDigestSummaries(ctx context.Context, res *digestResponse) {
+	for _, sum := range res.Summaries {
+		if sum.ID == 0 || strings.TrimSpace(sum.Summary) == "" {
+			continue
+		}
+		// /summary で作成済みの要約は上書きしない
+		if err := s.db.WithContext(ctx).Model(&models.Article{}).
+			Where("id = ? AND (summary = '' OR summary IS NULL)", sum.ID).
+			Update("summary", strings.TrimSpace(sum.Summary)).Error; err != nil {
+			s.logger.Error("要約の保存に失敗しました", zap.Uint("article_id", sum.ID), zap.Error(err))
+		}
+	}
+}
+
+func (s *SummaryService) summarizeBatch(ctx context.Context, batch []models.Article) (*digestResponse, error) {
+	var b strings.Builder
+	for _, a := range batch {
+		b.WriteString(formatDigestArticle(a))
+	}
+
+	raw, err := s.GenerateSummary(ctx, b.String(), 400+150*len(batch))
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := strings.Index(raw, "{"), strings.LastIndex(raw, "}")
+	if start < 0 || end <= start {
+		return nil, fmt.Errorf("応答にJSONが含まれていません")
+	}
+	var res digestResponse
+	if err := json.Unmarshal([]byte(raw[start:end+1]), &res); err != nil {
+		return nil, fmt.Errorf("応答JSONの解析に失敗しました: %w", err)
+	}
+	return &res, nil
+}
+
+func (s *SummaryService) mergeDigests(ctx context.Context, digests []string) (string, error) {
+	prompt := fmt.Sprintf(`次の複数のニュースダイジェストを1つの「6時間のまとめ」に統合してください。
+注目すべきトレンド、関心度が高いテーマ、緊急度の高いニュースを3～5行でレポートし、本文のみを出力してください。
+
+%s`, strings.Join(digests, "\n\n---\n\n"))
+
+	return s.complete(ctx, prompt, 500)
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"bot/ai"
+	"bot/config"
+	"bot/models"
+
+	"go.uber.org/zap"
+)
+
+// TestRunDigestIncludesSummarizedArticles は /summary で要約済みの記事もダイジェストに含め、
+// その要約を上書きしないことを確認します。
+func TestRunDigestIncludesSummarizedArticles(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	articles := []models.Article{
+		{Title: "要約済みの記事", URL: "https://kabutan.jp/news/1", Hash: "h1", Summary: "既存の要約", PublishedAt: now},
+		{Title: "未要約の記事", URL: "https://kabutan.jp/news/2", Hash: "h2", Body: "本文", PublishedAt: now},
+		{Title: "期間外の記事", URL: "https://kabutan.jp/news/3", Hash: "h3", PublishedAt: now.Add(-24 * time.Hour), CreatedAt: now.Add(-24 * time.Hour)},
+	}
+	for idx := range articles {
+		if err := db.Create(&articles[idx]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var prompt string
+	fake := &ai.Fake{Respond: func(req ai.Request) (string, error) {
+		prompt = req.Messages[len(req.Messages)-1].Content
+		return fmt.Sprintf(`{"summaries":[{"id":%d,"summary":"上書き"},{"id":%d,"summary":"新しい要約"}],"digest":"まとめ"}`,
+			articles[0].ID, articles[1].ID), nil
+	}}
+	s := NewSummaryServiceWithProvider(fake, &config.AIConfig{}, zap.NewNop(), db, nil)
+
+	digest, err := s.RunDigest(context.Background(), now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("RunDigest: %v", err)
+	}
+	if digest == nil || digest.Articles != 2 || digest.Text != "まとめ" {
+		t.Fatalf("digest = %+v, want 2 articles", digest)
+	}
+	if !strings.Contains(prompt, "既存の要約") || !strings.Contains(prompt, "未要約の記事") || strings.Contains(prompt, "期間外の記事") {
+		t.Errorf("prompt does not cover the window:\n%s", prompt)
+	}
+
+	summaries := map[uint]string{}
+	for _, a := range articles[:2] {
+		var got models.Article
+		db.First(&got, a.ID)
+		summaries[a.ID] = got.Summary
+	}
+	if summaries[articles[0].ID] != "既存の要約" {
+		t.Errorf("existing summary overwritten: %q", summaries[articles[0].ID])
+	}
+	if summaries[articles[1].ID] != "新しい要約" {
+		t.Errorf("new summary = %q, want 新しい要約", summaries[articles[1].ID])
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/spf13/viper"
+)
+
+// bodySelectors はホストごとの本文セレクタです。先頭から順に試し、最初に本文が取れたものを使います。
+var bodySelectors = map[string][]string{
+	"kabutan.jp": {
+		"#shijyounews article .body",
+		"#shijyounews article",
+		"div.body",
+	},
+	"www.traders.co.jp": {
+		".news_body",
+		"#news_body",
+		".article_body",
+		"article",
+	},
+}
+
+// fallbackSelectors はホスト別の定義がない、または一致しなかった場合に使います。
+var fallbackSelectors = []string{"article", "main", "#main"}
+
+// FetchArticleBody は記事ページを取得し、本文テキストを抽出します。
+func FetchArticleBody(ctx context.Context, client *http.Client, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("URLの解析に失敗しました: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("リクエストの作成に失敗しました: %w", err)
+	}
+	ua := viper.GetString("scraping.user_agent")
+	if ua == "" {
+		ua = "Mozilla/5.0"
+	}
+	req.Header.Set("User-Agent", ua)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("記事ページの取得に失敗しました: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("記事ページがエラーステータスを返しました: %s", resp.Status)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("HTMLの解析に失敗しました: %w", err)
+	}
+	return ExtractBody(doc, u.Host), nil
+}
+
+// ExtractBody はホストに対応するセレクタで本文を探し、整形したテキストを返します。
+func ExtractBody(doc *goquery.Document, host string) string {
+	doc.Find("script, style, noscript, iframe, form, nav, aside").Remove()
+
+	selectors := append(append([]string(nil), bodySelectors[host]...), fallbackSelectors...)
+	for _, sel := range selectors {
+		if text := cleanText(doc.Find(sel).First().Text()); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// cleanText は行ごとに前後の空白を除き、空行を詰めます。
+func cleanText(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,566 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"bot/models"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultNotifyPollInterval    = 2 * time.Second
+	defaultNotifyChannelInterval = time.Second
+	defaultNotifyMaxAttempts     = 8
+	defaultNotifyBurstThreshold  = 3
+	defaultNotifyRetryBaseDelay  = 5 * time.Second
+	defaultNotifyRetryMaxDelay   = 10 * time.Minute
+
+	// Discord の1メッセージあたりの上限
+	maxEmbedsPerMessage     = 10
+	maxEmbedCharsPerMessage = 6000
+	maxButtonsPerRow        = 5
+	maxButtonRows           = 5
+
+	// notifyBatchSize は1回の送信処理で読み込む送信待ちメッセージの上限
+	notifyBatchSize = 200
+)
+
+// OutboundPayload は送信待ちメッセージの内容です。
+// ボタンはリンクのみのため、JSON に保存できるよう Links として持ちます。
+type OutboundPayload struct {
+	Content string                    `json:"content,omitempty"`
+	Embeds  []*discordgo.MessageEmbed `json:"embeds"`
+	Links   []OutboundLink            `json:"links,omitempty"`
+	// MentionRoles と MentionUsers は Content でメンションするロール・ユーザーのID。
+	// どちらかを指定した場合、ここにないロールやユーザーには通知されない
+	MentionRoles []string `json:"mention_roles,omitempty"`
+	MentionUsers []string `json:"mention_users,omitempty"`
+	// Single が true の場合は他のメッセージとまとめずに送る。まとめた送信が拒否された場合に設定する
+	Single bool `json:"single,omitempty"`
+}
+
+// OutboundLink は「記事へ」などのリンクボタンです。
+type OutboundLink struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// mergeable はメンション等の本文がなく、他のメッセージとまとめて送れるかどうかを返します。
+func (p OutboundPayload) mergeable() bool {
+	return p.Content == "" && !p.Single
+}
+
+// discordSender は Notifier が使う Discord の API です。テストでは偽物に差し替えます。
+type discordSender interface {
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+}
+
+// Notifier は Discord への通知を DB の送信待ちキュー経由で送ります。
+// 同じチャンネルへの送信は notify.channel_interval 以上の間隔を空け、失敗した場合は指数バックオフで再送します。
+// 再送待ちのメッセージがあるチャンネルには、順序を保つためそれより新しいメッセージを送りません。
+// 同じチャンネルに notify.burst_threshold 件以上たまった場合は、最大10件の Embed を1メッセージにまとめます。
+// まとめた送信が 4xx で拒否された場合は、1件ずつ送り直します。
+type Notifier struct {
+	db      *gorm.DB
+	discord discordSender
+	logger  *zap.Logger
+	limiter *domainLimiter
+
+	pollInterval   time.Duration
+	maxAttempts    int
+	burstThreshold int
+	baseDelay      time.Duration
+	maxDelay       time.Duration
+
+	wake chan struct{}
+	// sending は送信処理中にロックされる。Drain と Start のワーカーが同時に送らないようにする
+	sending sync.Mutex
+	wg      sync.WaitGroup
+	// dmChannels はユーザーIDごとの DM チャンネルID。sending のロック中に使う
+	dmChannels map[string]string
+}
+
+func NewNotifier(db *gorm.DB, discord *discordgo.Session, logger *zap.Logger) *Notifier {
+	pollInterval := viper.GetDuration("notify.poll_interval")
+	if pollInterval <= 0 {
+		pollInterval = defaultNotifyPollInterval
+	}
+	channelInterval := viper.GetDuration("notify.channel_interval")
+	if channelInterval <= 0 {
+		channelInterval = defaultNotifyChannelInterval
+	}
+	maxAttempts := viper.GetInt("notify.max_attempts")
+	if maxAttempts <= 0 {
+		maxAttempts = defaultNotifyMaxAttempts
+	}
+	burst := viper.GetInt("notify.burst_threshold")
+	if burst <= 0 {
+		burst = defaultNotifyBurstThreshold
+	}
+	baseDelay := viper.GetDuration("notify.retry_base_delay")
+	if baseDelay <= 0 {
+		baseDelay = defaultNotifyRetryBaseDelay
+	}
+	maxDelay := viper.GetDuration("notify.retry_max_delay")
+	if maxDelay <= 0 {
+		maxDelay = defaultNotifyRetryMaxDelay
+	}
+
+	return &Notifier{
+		db:             db,
+		discord:        discord,
+		logger:         logger,
+		limiter:        newDomainLimiter(channelInterval),
+		pollInterval:   pollInterval,
+		maxAttempts:    maxAttempts,
+		burstThreshold: burst,
+		baseDelay:      baseDelay,
+		maxDelay:       maxDelay,
+		wake:           make(chan struct{}, 1),
+		dmChannels:     make(map[string]string),
+	}
+}
+
+// Enqueue はメッセージを送信待ちに追加し、送信ワーカーを起こします。
+func (n *Notifier) Enqueue(ctx context.Context, channelID string, payload OutboundPayload) error {
+	if channelID == "" {
+		return errors.New("送信先のチャンネルが設定されていません")
+	}
+	return n.enqueue(ctx, &models.OutboundMessage{ChannelID: channelID}, payload)
+}
+
+// EnqueueDM はユーザーへの DM を送信待ちに追加します。DM のチャンネルは送信時に開きます。
+func (n *Notifier) EnqueueDM(ctx context.Context, userID string, payload OutboundPayload) error {
+	if userID == "" {
+		return errors.New("送信先のユーザーが指定されていません")
+	}
+	return n.enqueue(ctx, &models.OutboundMessage{UserID: userID}, payload)
+}
+
+func (n *Notifier) enqueue(ctx context.Context, msg *models.OutboundMessage, payload OutboundPayload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("通知内容の変換に失敗しました: %w", err)
+	}
+	msg.Payload = string(data)
+	msg.NextAttemptAt = time.Now()
+	if err := n.db.WithContext(ctx).Create(msg).Error; err != nil {
+		return fmt.Errorf("通知の保存に失敗しました: %w", err)
+	}
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start は送信ワーカーを起動します。前回の起動時に送れなかったメッセージもここで送ります。
+func (n *Notifier) Start(ctx context.Context) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ticker := time.NewTicker(n.pollInterval)
+		defer ticker.Stop()
+		for {
+			n.flush(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-n.wake:
+			}
+		}
+	}()
+	n.logger.Info("通知の送信ワーカーを起動しました", zap.Duration("poll_interval", n.pollInterval))
+}
+
+// Wait は Start で起動したワーカーの終了を待ちます。
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+// Drain は今送れるメッセージがなくなるか ctx が終了するまで送信を続けます。
+// 送れなかったメッセージは DB に残り、次回の起動時に送られます。
+func (n *Notifier) Drain(ctx context.Context) error {
+	for {
+		n.flush(ctx)
+		pending, err := n.sendable(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(n.pollInterval):
+		}
+	}
+}
+
+// Prune は before より前に送信済みまたは送信を諦めたメッセージを削除し、削除件数を返します。
+func (n *Notifier) Prune(ctx context.Context, before time.Time) (int64, error) {
+	res := n.db.WithContext(ctx).
+		Where("(sent_at IS NOT NULL AND sent_at < ?) OR (failed_at IS NOT NULL AND failed_at < ?)", before, before).
+		Delete(&models.OutboundMessage{})
+	return res.RowsAffected, res.Error
+}
+
+// sendable は今送れるメッセージを古い順に返します。
+// 送信先ごとに、再送待ちのメッセージより新しいものは送信時刻を迎えていても含めません。
+func (n *Notifier) sendable(ctx context.Context) ([]models.OutboundMessage, error) {
+	now := time.Now()
+	var waiting []models.OutboundMessage
+	if err := n.db.WithContext(ctx).
+		Select("id", "channel_id", "user_id").
+		Where("sent_at IS NULL AND failed_at IS NULL AND next_attempt_at > ?", now).
+		Order("id").
+		Find(&waiting).Error; err != nil {
+		return nil, err
+	}
+
+	query := n.db.WithContext(ctx).
+		Where("sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?", now)
+	blocked := make(map[string]bool)
+	for _, msg := range waiting {
+		if key := queueKey(msg); !blocked[key] {
+			blocked[key] = true
+			query = query.Where("NOT (COALESCE(channel_id, '') = ? AND COALESCE(user_id, '') = ? AND id > ?)",
+				msg.ChannelID, msg.UserID, msg.ID)
+		}
+	}
+
+	var pending []models.OutboundMessage
+	if err := query.Order("id").Limit(notifyBatchSize).Find(&pending).Error; err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// queueKey は順序を保つ単位となる送信先です。DM はユーザーごとです。
+func queueKey(msg models.OutboundMessage) string {
+	if msg.ChannelID != "" {
+		return msg.ChannelID
+	}
+	return "dm:" + msg.UserID
+}
+
+// flush は今送れるメッセージを送信先ごとに古い順に送ります。
+// ある送信先で失敗した場合、順序を保つためその送信先の残りは失敗したメッセージの再送後に回します。
+func (n *Notifier) flush(ctx context.Context) {
+	n.sending.Lock()
+	defer n.sending.Unlock()
+
+	pending, err := n.sendable(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			n.logger.Error("送信待ちの通知の取得に失敗しました", zap.Error(err))
+		}
+		return
+	}
+
+	var order []string
+	byKey := make(map[string][]outbound)
+	for _, msg := range pending {
+		var payload OutboundPayload
+		if err := json.Unmarshal([]byte(msg.Payload), &payload); err != nil {
+			n.giveUp(ctx, []outbound{{msg: msg}}, fmt.Errorf("通知内容を読み込めません: %w", err))
+			continue
+		}
+		key := queueKey(msg)
+		if _, ok := byKey[key]; !ok {
+			order = append(order, key)
+		}
+		byKey[key] = append(byKey[key], outbound{msg: msg, payload: payload})
+	}
+
+	for _, key := range order {
+		queue := byKey[key]
+		channelID, err := n.channelFor(ctx, queue[0].msg)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			n.retry(ctx, queue[:1], err)
+			continue
+		}
+		for len(queue) > 0 {
+			batch := n.nextBatch(queue)
+			if err := n.limiter.Wait(ctx, channelID); err != nil {
+				return
+			}
+			if err := n.send(ctx, channelID, batch); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				n.retry(ctx, batch, err)
+				break
+			}
+			n.markSent(ctx, batch)
+			queue = queue[len(batch):]
+		}
+	}
+}
+
+// channelFor は送信先のチャンネルIDを返します。DM の場合はユーザーとの DM チャンネルを開きます。
+func (n *Notifier) channelFor(ctx context.Context, msg models.OutboundMessage) (string, error) {
+	if msg.ChannelID != "" {
+		return msg.ChannelID, nil
+	}
+	if channelID, ok := n.dmChannels[msg.UserID]; ok {
+		return channelID, nil
+	}
+	ch, err := n.discord.UserChannelCreate(msg.UserID,
+		discordgo.WithContext(ctx),
+		discordgo.WithRetryOnRatelimit(false))
+	if err != nil {
+		return "", fmt.Errorf("DMチャンネルの作成に失敗しました: %w", err)
+	}
+	n.dmChannels[msg.UserID] = ch.ID
+	return ch.ID, nil
+}
+
+// outbound は DB の行と、その内容を読み込んだものです。
+type outbound struct {
+	msg     models.OutboundMessage
+	payload OutboundPayload
+}
+
+// nextBatch は次に1メッセージで送る分を返します。
+// 送信待ちが burstThreshold 件未満の場合は1件ずつ、それ以上の場合は Embed の数と文字数の上限までまとめます。
+func (n *Notifier) nextBatch(queue []outbound) []outbound {
+	if len(queue) < n.burstThreshold || !queue[0].payload.mergeable() {
+		return queue[:1]
+	}
+	embeds, chars := 0, 0
+	end := 0
+	for _, o := range queue {
+		if !o.payload.mergeable() {
+			break
+		}
+		size := 0
+		for _, e := range o.payload.Embeds {
+			size += embedChars(e)
+		}
+		if end > 0 && (embeds+len(o.payload.Embeds) > maxEmbedsPerMessage || chars+size > maxEmbedCharsPerMessage) {
+			break
+		}
+		embeds += len(o.payload.Embeds)
+		chars += size
+		end++
+	}
+	return queue[:end]
+}
+
+func (n *Notifier) send(ctx context.Context, channelID string, batch []outbound) error {
+	send := &discordgo.MessageSend{}
+	var links []OutboundLink
+	for _, o := range batch {
+		send.Content = o.payload.Content
+		if len(o.payload.MentionRoles) > 0 || len(o.payload.MentionUsers) > 0 {
+			send.AllowedMentions = &discordgo.MessageAllowedMentions{Roles: o.payload.MentionRoles, Users: o.payload.MentionUsers}
+		}
+		send.Embeds = append(send.Embeds, o.payload.Embeds...)
+		links = append(links, o.payload.Links...)
+	}
+	if len(batch) > 1 {
+		// まとめた場合はどの記事のボタンか分かるよう、Embed の並び順の番号を付ける
+		numbered := make([]OutboundLink, 0, len(links))
+		for idx, o := range batch {
+			for _, l := range o.payload.Links {
+				numbered = append(numbered, OutboundLink{Label: fmt.Sprintf("%d. %s", idx+1, l.Label), URL: l.URL})
+			}
+		}
+		links = numbered
+	}
+	send.Components = linkRows(links)
+
+	// 429 は discordgo 内で待たずに返させ、他のチャンネルの送信を止めないようにする
+	_, err := n.discord.ChannelMessageSendComplex(channelID, send,
+		discordgo.WithContext(ctx),
+		discordgo.WithRetryOnRatelimit(false))
+	return err
+}
+
+// linkRows はリンクボタンを1行5個ずつ、最大5行に並べます。
+func linkRows(links []OutboundLink) []discordgo.MessageComponent {
+	var rows []discordgo.MessageComponent
+	for start := 0; start < len(links) && len(rows) < maxButtonRows; start += maxButtonsPerRow {
+		end := start + maxButtonsPerRow
+		if end > len(links) {
+			end = len(links)
+		}
+		row := discordgo.ActionsRow{}
+		for _, l := range links[start:end] {
+			row.Components = append(row.Components, discordgo.Button{
+				Label: l.Label,
+				Style: discordgo.LinkButton,
+				URL:   l.URL,
+				Emoji: &discordgo.ComponentEmoji{Name: "🔗"},
+			})
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// markSent は送信済みを記録します。送信後に ctx がキャンセルされても記録できるよう、キャンセルを引き継ぎません。
+func (n *Notifier) markSent(ctx context.Context, batch []outbound) {
+	now := time.Now()
+	if err := n.db.WithContext(context.WithoutCancel(ctx)).Model(&models.OutboundMessage{}).
+		Where("id IN ?", outboundIDs(batch)).
+		Updates(map[string]interface{}{"sent_at": now, "attempts": gorm.Expr("attempts + 1")}).Error; err != nil {
+		// 送信済みにできないと再送されるため、重複通知の可能性として記録する
+		n.logger.Error("通知の送信済み記録に失敗しました", zap.Uints("ids", outboundIDs(batch)), zap.Error(err))
+	}
+}
+
+// retry は失敗回数を増やして次の送信時刻を設定します。上限に達したものは諦めます。
+// レート制限の場合は Discord が指定した時間だけ待ちます。
+func (n *Notifier) retry(ctx context.Context, batch []outbound, cause error) {
+	var rl *discordgo.RateLimitError
+	rateLimited := errors.As(cause, &rl)
+	var restErr *discordgo.RESTError
+	if errors.As(cause, &restErr) && restErr.Response != nil &&
+		restErr.Response.StatusCode >= 400 && restErr.Response.StatusCode < 500 &&
+		restErr.Response.StatusCode != http.StatusTooManyRequests {
+		if len(batch) > 1 {
+			// まとめたことで上限を超えた可能性があるため、1件ずつ送り直して拒否されるものだけを諦める
+			n.split(ctx, batch, cause)
+			return
+		}
+		// 権限不足や不正な内容は再送しても成功しない
+		n.giveUp(ctx, batch, cause)
+		return
+	}
+
+	for _, o := range batch {
+		attempts := o.msg.Attempts + 1
+		if attempts >= n.maxAttempts && !rateLimited {
+			n.giveUp(ctx, []outbound{o}, cause)
+			continue
+		}
+		delay := backoff(n.baseDelay, n.maxDelay, attempts)
+		if rateLimited && rl.TooManyRequests != nil {
+			delay = rl.RetryAfter
+			// レート制限は失敗回数に数えない
+			attempts = o.msg.Attempts
+		}
+		if err := n.db.WithContext(context.WithoutCancel(ctx)).Model(&models.OutboundMessage{}).
+			Where("id = ?", o.msg.ID).
+			Updates(map[string]interface{}{
+				"attempts":        attempts,
+				"next_attempt_at": time.Now().Add(delay),
+				"last_error":      truncateError(cause),
+			}).Error; err != nil {
+			n.logger.Error("通知の再送予定の保存に失敗しました", zap.Uint("id", o.msg.ID), zap.Error(err))
+		}
+	}
+	n.logger.Warn("通知の送信に失敗したため再送します",
+		zap.String("to", queueKey(batch[0].msg)),
+		zap.Int("messages", len(batch)),
+		zap.Bool("rate_limited", rateLimited),
+		zap.Error(cause))
+}
+
+// split はまとめて送って拒否されたメッセージを、1件ずつすぐに送り直すよう保存し直します。
+// まとめたことによる失敗のため、失敗回数には数えません。
+func (n *Notifier) split(ctx context.Context, batch []outbound, cause error) {
+	now := time.Now()
+	for _, o := range batch {
+		o.payload.Single = true
+		data, err := json.Marshal(o.payload)
+		if err != nil {
+			n.giveUp(ctx, []outbound{o}, fmt.Errorf("通知内容を保存できません: %w", err))
+			continue
+		}
+		if err := n.db.WithContext(context.WithoutCancel(ctx)).Model(&models.OutboundMessage{}).
+			Where("id = ?", o.msg.ID).
+			Updates(map[string]interface{}{
+				"payload":         string(data),
+				"next_attempt_at": now,
+				"last_error":      truncateError(cause),
+			}).Error; err != nil {
+			n.logger.Error("通知の再送予定の保存に失敗しました", zap.Uint("id", o.msg.ID), zap.Error(err))
+		}
+	}
+	n.logger.Warn("まとめた通知が拒否されたため1件ずつ送り直します",
+		zap.String("to", queueKey(batch[0].msg)),
+		zap.Uints("ids", outboundIDs(batch)),
+		zap.Error(cause))
+}
+
+// giveUp は送信を諦めたことを記録します。markSent と同様に ctx のキャンセルを引き継ぎません。
+func (n *Notifier) giveUp(ctx context.Context, batch []outbound, cause error) {
+	now := time.Now()
+	if err := n.db.WithContext(context.WithoutCancel(ctx)).Model(&models.OutboundMessage{}).
+		Where("id IN ?", outboundIDs(batch)).
+		Updates(map[string]interface{}{
+			"attempts":   gorm.Expr("attempts + 1"),
+			"failed_at":  now,
+			"last_error": truncateError(cause),
+		}).Error; err != nil {
+		n.logger.Error("通知の失敗記録に失敗しました", zap.Uints("ids", outboundIDs(batch)), zap.Error(err))
+	}
+	n.logger.Error("通知の送信を諦めました",
+		zap.String("to", queueKey(batch[0].msg)),
+		zap.Uints("ids", outboundIDs(batch)),
+		zap.Error(cause))
+}
+
+// backoff は attempts 回目の失敗後の待ち時間です。
+func backoff(base, limit time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+func outboundIDs(batch []outbound) []uint {
+	ids := make([]uint, 0, len(batch))
+	for _, o := range batch {
+		ids = append(ids, o.msg.ID)
+	}
+	return ids
+}
+
+// truncateError は LastError の列に収まるようエラーメッセージを切り詰めます。
+func truncateError(err error) string {
+	r := []rune(err.Error())
+	if len(r) > 500 {
+		r = r[:500]
+	}
+	return string(r)
+}
+
+// embedChars は Discord が1メッセージあたり6000文字までと数える Embed の文字数です。
+func embedChars(e *discordgo.MessageEmbed) int {
+	n := utf8.RuneCountInString(e.Title) + utf8.RuneCountInString(e.Description)
+	if e.Author != nil {
+		n += utf8.RuneCountInString(e.Author.Name)
+	}
+	if e.Footer != nil {
+		n += utf8.RuneCountInString(e.Footer.Text)
+	}
+	for _, f := range e.Fields {
+		n += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+	}
+	return n
+}
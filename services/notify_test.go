@@ -0,0 +1,429 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bot/models"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// fakeDiscord は送信内容を記録する discordSender です。fail が nil 以外を返した場合は送信に失敗します。
+type fakeDiscord struct {
+	mu        sync.Mutex
+	sent      []fakeSent
+	dmOpened  []string
+	fail      func(channelID string, data *discordgo.MessageSend) error
+	dmFailure error
+}
+
+type fakeSent struct {
+	channelID string
+	data      *discordgo.MessageSend
+}
+
+func (f *fakeDiscord) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail != nil {
+		if err := f.fail(channelID, data); err != nil {
+			return nil, err
+		}
+	}
+	f.sent = append(f.sent, fakeSent{channelID: channelID, data: data})
+	return &discordgo.Message{ChannelID: channelID}, nil
+}
+
+func (f *fakeDiscord) UserChannelCreate(recipientID string, _ ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dmFailure != nil {
+		return nil, f.dmFailure
+	}
+	f.dmOpened = append(f.dmOpened, recipientID)
+	return &discordgo.Channel{ID: "dm-" + recipientID}, nil
+}
+
+// titles は送信したメッセージの Content または Embed のタイトルを送信順に返します。
+func (f *fakeDiscord) titles() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for _, s := range f.sent {
+		var parts []string
+		if s.data.Content != "" {
+			parts = append(parts, s.data.Content)
+		}
+		for _, e := range s.data.Embeds {
+			parts = append(parts, e.Title)
+		}
+		out = append(out, s.channelID+":"+strings.Join(parts, "+"))
+	}
+	return out
+}
+
+func newTestNotifier(t *testing.T, fake *fakeDiscord) *Notifier {
+	t.Helper()
+	n := NewNotifier(openTestDB(t), nil, zap.NewNop())
+	n.discord = fake
+	n.limiter = newDomainLimiter(0)
+	n.pollInterval = time.Millisecond
+	return n
+}
+
+func embedPayload(titles ...string) OutboundPayload {
+	var p OutboundPayload
+	for _, title := range titles {
+		p.Embeds = append(p.Embeds, &discordgo.MessageEmbed{Title: title})
+	}
+	return p
+}
+
+func loadOutbound(t *testing.T, n *Notifier, id uint) models.OutboundMessage {
+	t.Helper()
+	var msg models.OutboundMessage
+	if err := n.db.First(&msg, id).Error; err != nil {
+		t.Fatalf("load outbound %d: %v", id, err)
+	}
+	return msg
+}
+
+// enqueueOne は1件追加し、その行を返します。
+func enqueueOne(t *testing.T, n *Notifier, channelID string, payload OutboundPayload) models.OutboundMessage {
+	t.Helper()
+	if err := n.Enqueue(context.Background(), channelID, payload); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	var msg models.OutboundMessage
+	if err := n.db.Order("id DESC").First(&msg).Error; err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestNextBatch(t *testing.T) {
+	n := &Notifier{burstThreshold: 3}
+	item := func(id uint, p OutboundPayload) outbound {
+		return outbound{msg: models.OutboundMessage{ID: id}, payload: p}
+	}
+	long := func(title string) OutboundPayload {
+		return OutboundPayload{Embeds: []*discordgo.MessageEmbed{{Title: title, Description: strings.Repeat("あ", 2500)}}}
+	}
+	mention := embedPayload("mention")
+	mention.Content = "<@&1>"
+
+	tests := []struct {
+		name  string
+		queue []outbound
+		want  [][]uint
+	}{
+		{
+			name:  "below threshold sends one by one",
+			queue: []outbound{item(1, embedPayload("a")), item(2, embedPayload("b"))},
+			want:  [][]uint{{1}, {2}},
+		},
+		{
+			name:  "burst merges in order",
+			queue: []outbound{item(1, embedPayload("a")), item(2, embedPayload("b")), item(3, embedPayload("c")), item(4, embedPayload("d"))},
+			want:  [][]uint{{1, 2, 3, 4}},
+		},
+		{
+			// 4件目を加えると Embed が12個になる。残りは閾値未満のため1件ずつ
+			name: "embed limit",
+			queue: []outbound{
+				item(1, embedPayload("a1", "a2", "a3")), item(2, embedPayload("b1", "b2", "b3")),
+				item(3, embedPayload("c1", "c2", "c3")), item(4, embedPayload("d1", "d2", "d3")),
+			},
+			want: [][]uint{{1, 2, 3}, {4}},
+		},
+		{
+			name:  "character limit",
+			queue: []outbound{item(1, long("a")), item(2, long("b")), item(3, long("c")), item(4, long("d"))},
+			want:  [][]uint{{1, 2}, {3}, {4}},
+		},
+		{
+			// メンション付きはまとめず、それより後ろも追い越さない
+			name: "unmergeable stops the batch",
+			queue: []outbound{
+				item(1, embedPayload("a")), item(2, embedPayload("b")), item(3, mention),
+				item(4, embedPayload("d")), item(5, embedPayload("e")), item(6, embedPayload("f")),
+			},
+			want: [][]uint{{1, 2}, {3}, {4, 5, 6}},
+		},
+		{
+			name:  "unmergeable head",
+			queue: []outbound{item(1, mention), item(2, embedPayload("b")), item(3, embedPayload("c")), item(4, embedPayload("d"))},
+			want:  [][]uint{{1}, {2, 3, 4}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]uint
+			queue := tt.queue
+			for len(queue) > 0 {
+				batch := n.nextBatch(queue)
+				got = append(got, outboundIDs(batch))
+				queue = queue[len(batch):]
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("batches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotifyBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{4, 40 * time.Second},
+		{5, time.Minute},
+		{30, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := backoff(5*time.Second, time.Minute, tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRetrySchedulesBackoff(t *testing.T) {
+	n := newTestNotifier(t, &fakeDiscord{})
+	ctx := context.Background()
+	msg := enqueueOne(t, n, "100", embedPayload("a"))
+
+	start := time.Now()
+	n.retry(ctx, []outbound{{msg: msg}}, errors.New("connection reset"))
+	got := loadOutbound(t, n, msg.ID)
+	if got.Attempts != 1 || got.FailedAt != nil || got.LastError != "connection reset" {
+		t.Fatalf("after retry: attempts = %d, failed_at = %v, last_error = %q", got.Attempts, got.FailedAt, got.LastError)
+	}
+	if wait := got.NextAttemptAt.Sub(start); wait < n.baseDelay || wait > n.baseDelay+time.Second {
+		t.Errorf("next attempt in %v, want %v", wait, n.baseDelay)
+	}
+
+	// 2回目は待ち時間が倍になる
+	start = time.Now()
+	n.retry(ctx, []outbound{{msg: got}}, errors.New("connection reset"))
+	got = loadOutbound(t, n, msg.ID)
+	if wait := got.NextAttemptAt.Sub(start); got.Attempts != 2 || wait < 2*n.baseDelay || wait > 2*n.baseDelay+time.Second {
+		t.Errorf("second retry: attempts = %d, next attempt in %v", got.Attempts, wait)
+	}
+
+	// 上限回数に達したら諦める
+	got.Attempts = n.maxAttempts - 1
+	n.retry(ctx, []outbound{{msg: got}}, errors.New("connection reset"))
+	if got = loadOutbound(t, n, msg.ID); got.FailedAt == nil {
+		t.Errorf("failed_at not set after %d attempts", n.maxAttempts)
+	}
+}
+
+func TestRetryRateLimitIsNotCounted(t *testing.T) {
+	n := newTestNotifier(t, &fakeDiscord{})
+	msg := enqueueOne(t, n, "100", embedPayload("a"))
+	// 上限回数でもレート制限なら諦めない
+	msg.Attempts = n.maxAttempts - 1
+
+	rl := &discordgo.RateLimitError{RateLimit: &discordgo.RateLimit{TooManyRequests: &discordgo.TooManyRequests{RetryAfter: 3 * time.Second}}}
+	start := time.Now()
+	n.retry(context.Background(), []outbound{{msg: msg}}, rl)
+	got := loadOutbound(t, n, msg.ID)
+	if got.Attempts != msg.Attempts || got.FailedAt != nil {
+		t.Fatalf("attempts = %d, failed_at = %v, want attempts unchanged and not failed", got.Attempts, got.FailedAt)
+	}
+	if wait := got.NextAttemptAt.Sub(start); wait < 3*time.Second || wait > 4*time.Second {
+		t.Errorf("next attempt in %v, want Retry-After of 3s", wait)
+	}
+}
+
+func TestRetryGivesUpOnClientError(t *testing.T) {
+	tests := []struct {
+		status int
+		giveUp bool
+	}{
+		{http.StatusForbidden, true},
+		{http.StatusBadRequest, true},
+		{http.StatusNotFound, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			n := newTestNotifier(t, &fakeDiscord{})
+			msg := enqueueOne(t, n, "100", embedPayload("a"))
+			cause := &discordgo.RESTError{Response: &http.Response{StatusCode: tt.status}}
+
+			n.retry(context.Background(), []outbound{{msg: msg}}, cause)
+			got := loadOutbound(t, n, msg.ID)
+			if (got.FailedAt != nil) != tt.giveUp {
+				t.Errorf("failed_at = %v, want given up = %v", got.FailedAt, tt.giveUp)
+			}
+			if got.Attempts != 1 {
+				t.Errorf("attempts = %d, want 1", got.Attempts)
+			}
+			if tt.giveUp && got.LastError == "" {
+				t.Error("last_error not recorded")
+			}
+		})
+	}
+}
+
+func TestFlushSplitsRejectedBatch(t *testing.T) {
+	badRequest := &discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusBadRequest}}
+	fake := &fakeDiscord{fail: func(channelID string, data *discordgo.MessageSend) error {
+		// まとめた送信と、a2 だけを含む送信を拒否する
+		if len(data.Embeds) > 1 || data.Embeds[0].Title == "a2" {
+			return badRequest
+		}
+		return nil
+	}}
+	n := newTestNotifier(t, fake)
+	n.burstThreshold = 2
+	ctx := context.Background()
+
+	a1 := enqueueOne(t, n, "100", embedPayload("a1"))
+	a2 := enqueueOne(t, n, "100", embedPayload("a2"))
+	a3 := enqueueOne(t, n, "100", embedPayload("a3"))
+
+	// まとめた送信が拒否されても諦めず、1件ずつ送り直す
+	n.flush(ctx)
+	for _, msg := range []models.OutboundMessage{a1, a2, a3} {
+		if got := loadOutbound(t, n, msg.ID); got.FailedAt != nil || got.Attempts != 0 {
+			t.Fatalf("after rejected batch: message %d attempts = %d, failed_at = %v", msg.ID, got.Attempts, got.FailedAt)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		n.flush(ctx)
+	}
+	if got, want := fake.titles(), []string{"100:a1", "100:a3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+	if got := loadOutbound(t, n, a2.ID); got.FailedAt == nil {
+		t.Error("a2 was not given up after being rejected on its own")
+	}
+}
+
+func TestMarkSentAfterCancel(t *testing.T) {
+	n := newTestNotifier(t, &fakeDiscord{})
+	msg := enqueueOne(t, n, "100", embedPayload("a"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// 送信後にシャットダウンでキャンセルされても、送信済みを記録して再送を防ぐ
+	n.markSent(ctx, []outbound{{msg: msg}})
+	if got := loadOutbound(t, n, msg.ID); got.SentAt == nil {
+		t.Error("sent_at not recorded with a canceled context")
+	}
+}
+
+func TestFlushKeepsChannelOrderWhileRetrying(t *testing.T) {
+	failing := true
+	fake := &fakeDiscord{fail: func(channelID string, data *discordgo.MessageSend) error {
+		if failing && channelID == "100" {
+			return errors.New("connection reset")
+		}
+		return nil
+	}}
+	n := newTestNotifier(t, fake)
+	ctx := context.Background()
+
+	head := enqueueOne(t, n, "100", embedPayload("a1"))
+	enqueueOne(t, n, "100", embedPayload("a2"))
+	enqueueOne(t, n, "200", embedPayload("b1"))
+
+	n.flush(ctx)
+	if got, want := fake.titles(), []string{"200:b1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first flush sent %v, want %v", got, want)
+	}
+
+	// a2 は送信時刻を迎えているが、a1 の再送待ちの間は送らない
+	failing = false
+	enqueueOne(t, n, "200", embedPayload("b2"))
+	n.flush(ctx)
+	if got, want := fake.titles(), []string{"200:b1", "200:b2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("second flush sent %v, want %v", got, want)
+	}
+	// 送れるものがなければ Drain は再送を待たずに戻る
+	drainCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := n.Drain(drainCtx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	// 再送時刻を迎えたら古い順に送る
+	if err := n.db.Model(&models.OutboundMessage{}).Where("id = ?", head.ID).
+		Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	n.flush(ctx)
+	if got, want := fake.titles(), []string{"200:b1", "200:b2", "100:a1", "100:a2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after retry sent %v, want %v", got, want)
+	}
+
+	var pending int64
+	n.db.Model(&models.OutboundMessage{}).Where("sent_at IS NULL").Count(&pending)
+	if pending != 0 {
+		t.Errorf("%d messages left unsent", pending)
+	}
+}
+
+func TestFlushSendsDM(t *testing.T) {
+	fake := &fakeDiscord{}
+	n := newTestNotifier(t, fake)
+	ctx := context.Background()
+
+	if err := n.EnqueueDM(ctx, "42", embedPayload("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.EnqueueDM(ctx, "42", embedPayload("second")); err != nil {
+		t.Fatal(err)
+	}
+	mention := embedPayload("mention")
+	mention.Content = "<@42>"
+	mention.MentionUsers = []string{"42"}
+	enqueueOne(t, n, "100", mention)
+
+	n.flush(ctx)
+	if got, want := fake.titles(), []string{"dm-42:first", "dm-42:second", "100:<@42>+mention"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("sent %v, want %v", got, want)
+	}
+	// DM チャンネルは1度だけ開く
+	if !reflect.DeepEqual(fake.dmOpened, []string{"42"}) {
+		t.Errorf("UserChannelCreate called for %v", fake.dmOpened)
+	}
+	if am := fake.sent[2].data.AllowedMentions; am == nil || !reflect.DeepEqual(am.Users, []string{"42"}) {
+		t.Errorf("AllowedMentions = %+v, want users [42]", am)
+	}
+}
+
+func TestFlushRetriesDMChannelFailure(t *testing.T) {
+	fake := &fakeDiscord{dmFailure: errors.New("connection reset")}
+	n := newTestNotifier(t, fake)
+	ctx := context.Background()
+
+	if err := n.EnqueueDM(ctx, "42", embedPayload("first")); err != nil {
+		t.Fatal(err)
+	}
+	n.flush(ctx)
+	if len(fake.sent) != 0 {
+		t.Fatalf("sent %v without a DM channel", fake.titles())
+	}
+	var msg models.OutboundMessage
+	n.db.First(&msg)
+	if msg.Attempts != 1 || msg.FailedAt != nil || !msg.NextAttemptAt.After(time.Now()) {
+		t.Errorf("attempts = %d, failed_at = %v, next_attempt_at = %v; want a scheduled retry", msg.Attempts, msg.FailedAt, msg.NextAttemptAt)
+	}
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrAlreadyRunning は同じサイトのスクレイピングが実行中の場合に返されます。
+	ErrAlreadyRunning = errors.New("スクレイピングは既に実行中です")
+	// ErrDisabled はサイトが /scrape toggle で無効化されている場合に返されます。
+	ErrDisabled = errors.New("スクレイピングは無効化されています")
+)
+
+// SiteFunc は1サイト分のスクレイピングと通知を行い、新着記事数を返します。
+type SiteFunc func(ctx context.Context) (int, error)
+
+// ScrapeResult は1サイト分のスクレイピング結果です。
+type ScrapeResult struct {
+	Site        string
+	NewArticles int
+	Duration    time.Duration
+	Err         error
+}
+
+type site struct {
+	run     SiteFunc
+	running sync.Mutex
+}
+
+// SiteRunner はサイトごとのスクレイピング処理を保持し、
+// 定期実行と手動実行の両方から同じ経路で呼び出せるようにします。
+type SiteRunner struct {
+	logger   *zap.Logger
+	settings *SettingsStore
+	mu       sync.RWMutex
+	order    []string
+	sites    map[string]*site
+}
+
+func NewSiteRunner(logger *zap.Logger, settings *SettingsStore) *SiteRunner {
+	return &SiteRunner{
+		logger:   logger,
+		settings: settings,
+		sites:    make(map[string]*site),
+	}
+}
+
+// Register はサイト名とスクレイピング処理を登録します。
+func (r *SiteRunner) Register(name string, fn SiteFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.sites[name]; !ok {
+		r.order = append(r.order, name)
+	}
+	r.sites[name] = &site{run: fn}
+}
+
+// Has はサイトが登録済みかどうかを返します。
+func (r *SiteRunner) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.sites[name]
+	return ok
+}
+
+// Sites は登録順のサイト名一覧を返します。
+func (r *SiteRunner) Sites() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.order...)
+}
+
+// Run は指定サイトのスクレイピングを実行します。
+// 無効化されている場合は ErrDisabled を、
+// 同じサイトが実行中の場合は待たずに ErrAlreadyRunning を返します。
+func (r *SiteRunner) Run(ctx context.Context, name string) ScrapeResult {
+	result := ScrapeResult{Site: name}
+
+	r.mu.RLock()
+	st, ok := r.sites[name]
+	r.mu.RUnlock()
+	if !ok {
+		result.Err = errors.New("未登録のサイトです: " + name)
+		return result
+	}
+
+	if !r.settings.Enabled(name) {
+		r.logger.Debug("無効化されているためスクレイピングをスキップしました", zap.String("site", name))
+		result.Err = ErrDisabled
+		return result
+	}
+
+	if !st.running.TryLock() {
+		r.logger.Info("実行中のためスクレイピングをスキップしました", zap.String("site", name))
+		result.Err = ErrAlreadyRunning
+		return result
+	}
+	defer st.running.Unlock()
+
+	start := time.Now()
+	result.NewArticles, result.Err = st.run(ctx)
+	result.Duration = time.Since(start)
+
+	if result.Err != nil {
+		r.logger.Error("スクレイピングに失敗しました",
+			zap.String("site", name),
+			zap.Duration("duration", result.Duration),
+			zap.Error(result.Err))
+	}
+	return result
+}
+
+// RunAll は全サイトを並行して実行し、登録順に結果を返します。
+func (r *SiteRunner) RunAll(ctx context.Context) []ScrapeResult {
+	names := r.Sites()
+	results := make([]ScrapeResult, len(names))
+
+	var wg sync.WaitGroup
+	for idx, name := range names {
+		wg.Add(1)
+		go func(idx int, name string) {
+			defer wg.Done()
+			results[idx] = r.Run(ctx, name)
+		}(idx, name)
+	}
+	wg.Wait()
+	return results
+}
+
+// Task は Scheduler.AddTask に渡すための関数を返します。
+// 無効化中・実行中によるスキップは失敗として扱いません。
+func (r *SiteRunner) Task(name string) TaskFunc {
+	return func(ctx context.Context) error {
+		result := r.Run(ctx, name)
+		if errors.Is(result.Err, ErrDisabled) || errors.Is(result.Err, ErrAlreadyRunning) {
+			return nil
+		}
+		return result.Err
+	}
+}
@@ -2,69 +2,381 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"sync"
 	"syscall"
 	"time"
 
+	"bot/market"
+
 	"github.com/bwmarrin/discordgo"
 	"github.com/go-co-op/gocron"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
 type Scheduler struct {
 	*gocron.Scheduler
+	discord        *discordgo.Session
 	logger         *zap.Logger
 	summaryService *SummaryService
+	calendar       *market.Calendar
+	// ctx は Start で渡されたタスク共通のコンテキスト
+	ctx context.Context
+
+	mu    sync.RWMutex
+	tasks []*task
+	// running は実行中のタスク数。Shutdown で完了を待つ
+	running sync.WaitGroup
+}
+
+// TaskStatus はタスクごとの実行状況のスナップショットです。
+type TaskStatus struct {
+	Name                string
+	Schedule            string
+	Running             bool
+	LastStart           time.Time
+	LastSuccess         time.Time
+	LastDuration        time.Duration
+	LastError           string
+	ConsecutiveFailures int
+	NextRun             time.Time
+	// Window は実行する時間帯、InWindow は現在その時間帯に入っているかどうか
+	Window   market.Window
+	InWindow bool
+	// LastSkipped は時間帯外のため最後に実行を見送った時刻
+	LastSkipped time.Time
+	// Timeout は1回の実行の期限、Overlap は前回の実行中に次の時刻が来た場合の扱い
+	Timeout time.Duration
+	Overlap OverlapMode
+	// SkippedOverlaps は前回の実行中だったため見送った回数、Panics はパニックで終了した回数
+	SkippedOverlaps int
+	Panics          int
+}
+
+// TaskFunc はスケジューラから呼ばれる処理です。
+// ctx はシャットダウンの期限切れまたは TaskOptions.Timeout でキャンセルされます。
+type TaskFunc func(ctx context.Context) error
+
+// OverlapMode は前回の実行が終わっていないときに次の実行時刻が来た場合の扱いです。
+type OverlapMode string
+
+const (
+	// OverlapSkip は今回の実行を見送る（既定）
+	OverlapSkip OverlapMode = "skip"
+	// OverlapQueue は前回の終了を待ってから実行する。待機できるのは1回分までで、それ以上は見送る
+	OverlapQueue OverlapMode = "queue"
+)
+
+// TaskOptions はタスクの実行条件です。
+// Timeout と Overlap は scheduler.tasks.<name>.timeout / overlap で上書きできます。
+type TaskOptions struct {
+	// Window が設定されている場合、東証の営業日のその時間帯（日本時間）にだけ実行する
+	Window market.Window
+	// Timeout は1回の実行の期限。0 の場合は scheduler.default_timeout を使う
+	Timeout time.Duration
+	// Overlap が空の場合は OverlapSkip
+	Overlap OverlapMode
+}
+
+type task struct {
+	job    *gocron.Job
+	opts   TaskOptions
+	status TaskStatus
+
+	// active は実行中の間ロックされる。queued は Overlap が queue のときに待機中の実行があるかどうか
+	active sync.Mutex
+	queued bool
+}
+
+// AddSummaryJob は直近 ai.digest_window の記事のダイジェスト投稿と、未要約記事の要約を定期実行します。
+// 投稿先は discord.digest_channel、未設定の場合は discord.alert_channel です。
+func (s *Scheduler) AddSummaryJob(schedule string, notifier *Notifier) {
+	s.AddTaskWithOptions("summary", schedule, TaskOptions{Timeout: 10 * time.Minute}, func(ctx context.Context) error {
+		window := viper.GetDuration("ai.digest_window")
+		if window <= 0 {
+			window = 6 * time.Hour
+		}
+		s.logger.Info("要約生成ジョブを開始します", zap.Duration("window", window))
+
+		digest, err := s.summaryService.RunDigest(ctx, time.Now().Add(-window))
+		if err != nil {
+			return err
+		}
+		if digest == nil {
+			s.logger.Info("要約対象の記事がありません")
+			return nil
+		}
+		return s.postDigest(ctx, notifier, digest)
+	})
 }
 
-func (s *Scheduler) AddSummaryJob(schedule string) {
-	_, err := s.Scheduler.Cron(schedule).Do(func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-		defer cancel()
-		
-		s.logger.Info("要約生成ジョブを開始します", zap.Any("context", ctx))
-		// TODO: データベースから未要約の記事を取得し、要約処理を実行
-		// s.summaryService.GenerateAndStoreSummary(ctx, articleID, content)
+func (s *Scheduler) postDigest(ctx context.Context, notifier *Notifier, d *Digest) error {
+	channelID := viper.GetString("discord.digest_channel")
+	if channelID == "" {
+		channelID = viper.GetString("discord.alert_channel")
+	}
+
+	jst := time.FixedZone("JST", 9*3600)
+	text := []rune(d.Text)
+	if len(text) > 4000 {
+		text = append(text[:4000], '…')
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:       "🗞 6時間のまとめ",
+		Description: string(text),
+		Color:       0x9B59B6,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "対象期間", Value: fmt.Sprintf("%s ～ %s", d.From.In(jst).Format("01/02 15:04"), d.To.In(jst).Format("01/02 15:04")), Inline: true},
+			{Name: "対象記事数", Value: fmt.Sprintf("%d件", d.Articles), Inline: true},
+		},
+		Timestamp: d.To.Format(time.RFC3339),
+		Footer:    &discordgo.MessageEmbedFooter{Text: "🤖 AIによる要約"},
+	}
+	if err := notifier.Enqueue(ctx, channelID, OutboundPayload{Embeds: []*discordgo.MessageEmbed{embed}}); err != nil {
+		return fmt.Errorf("ダイジェストの投稿に失敗しました: %w", err)
+	}
+	s.logger.Info("ダイジェストを送信待ちに追加しました",
+		zap.Int("articles", d.Articles),
+		zap.Int("batches", d.Batches))
+	return nil
+}
+
+// AddTask は名前付きのタスクを登録します。
+// 実行ごとに開始時刻・所要時間・連続失敗回数を記録し、Statuses で参照できます。
+// schedule の cron 式は日本時間で解釈します。
+func (s *Scheduler) AddTask(name, schedule string, fn TaskFunc) {
+	s.AddTaskWithOptions(name, schedule, TaskOptions{}, fn)
+}
+
+// AddTaskWithOptions は実行条件付きでタスクを登録します。
+// opts.Window の時間帯外に来た実行と、前回の実行中のため見送った実行は失敗として数えません。
+func (s *Scheduler) AddTaskWithOptions(name, schedule string, opts TaskOptions, fn TaskFunc) {
+	opts = taskOptionsFromConfig(name, opts)
+	t := &task{opts: opts, status: TaskStatus{
+		Name:     name,
+		Schedule: schedule,
+		Window:   opts.Window,
+		Timeout:  opts.Timeout,
+		Overlap:  opts.Overlap,
+	}}
+
+	job, err := s.Scheduler.Cron(schedule).Do(func() {
+		s.run(t, fn)
 	})
-	
 	if err != nil {
-		s.logger.Error("要約ジョブの追加に失敗しました",
+		s.logger.Error("タスクの追加に失敗しました",
+			zap.String("name", name),
 			zap.String("schedule", schedule),
 			zap.Error(err))
+		return
 	}
+	t.job = job
+
+	s.mu.Lock()
+	s.tasks = append(s.tasks, t)
+	s.mu.Unlock()
 }
 
-func (s *Scheduler) AddTask(schedule string, task func()) {
-	_, err := s.Scheduler.Cron(schedule).Do(task)
+// taskOptionsFromConfig は設定ファイルの値で opts を上書きし、未指定の項目に既定値を入れます。
+func taskOptionsFromConfig(name string, opts TaskOptions) TaskOptions {
+	key := "scheduler.tasks." + name
+	if d := viper.GetDuration(key + ".timeout"); d > 0 {
+		opts.Timeout = d
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = viper.GetDuration("scheduler.default_timeout")
+	}
+	if m := OverlapMode(viper.GetString(key + ".overlap")); m != "" {
+		opts.Overlap = m
+	}
+	if opts.Overlap != OverlapQueue {
+		opts.Overlap = OverlapSkip
+	}
+	return opts
+}
+
+func (s *Scheduler) run(t *task, fn TaskFunc) {
+	if !s.calendar.InWindow(t.opts.Window, time.Now()) {
+		s.mu.Lock()
+		t.status.LastSkipped = time.Now()
+		s.mu.Unlock()
+		s.logger.Debug("実行時間帯外のためタスクを見送りました",
+			zap.String("name", t.status.Name),
+			zap.Stringer("window", t.opts.Window))
+		return
+	}
+
+	s.running.Add(1)
+	defer s.running.Done()
+
+	if !s.acquire(t) {
+		s.mu.Lock()
+		t.status.SkippedOverlaps++
+		skipped := t.status.SkippedOverlaps
+		s.mu.Unlock()
+		s.logger.Warn("前回の実行が終わっていないためタスクを見送りました",
+			zap.String("name", t.status.Name),
+			zap.String("overlap", string(t.opts.Overlap)),
+			zap.Int("skipped_overlaps", skipped))
+		return
+	}
+	defer t.active.Unlock()
+
+	start := time.Now()
+	s.mu.Lock()
+	t.status.Running = true
+	t.status.LastStart = start
+	s.mu.Unlock()
+
+	ctx, cancel := s.ctx, context.CancelFunc(func() {})
+	if t.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(s.ctx, t.opts.Timeout)
+	}
+	panicked, err := s.call(ctx, t.status.Name, fn)
+	cancel()
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("タイムアウト (%s): %w", t.opts.Timeout, err)
+	}
+	duration := time.Since(start)
+
+	s.mu.Lock()
+	t.status.Running = false
+	t.status.LastDuration = duration
+	if panicked {
+		t.status.Panics++
+	}
 	if err != nil {
-		s.logger.Error("タスクの追加に失敗しました",
-			zap.String("schedule", schedule),
+		t.status.ConsecutiveFailures++
+		t.status.LastError = err.Error()
+	} else {
+		t.status.ConsecutiveFailures = 0
+		t.status.LastError = ""
+		t.status.LastSuccess = start.Add(duration)
+	}
+	failures := t.status.ConsecutiveFailures
+	s.mu.Unlock()
+
+	if err != nil {
+		s.logger.Error("タスクの実行に失敗しました",
+			zap.String("name", t.status.Name),
+			zap.Duration("duration", duration),
+			zap.Int("consecutive_failures", failures),
 			zap.Error(err))
 	}
 }
 
+// acquire は t の実行権を取ります。Overlap が queue の場合は1回分だけ前回の終了を待ちます。
+// 実行を見送る場合は false を返します。
+func (s *Scheduler) acquire(t *task) bool {
+	if t.active.TryLock() {
+		return true
+	}
+	if t.opts.Overlap != OverlapQueue {
+		return false
+	}
+
+	s.mu.Lock()
+	if t.queued {
+		s.mu.Unlock()
+		return false
+	}
+	t.queued = true
+	s.mu.Unlock()
+
+	t.active.Lock()
+
+	s.mu.Lock()
+	t.queued = false
+	s.mu.Unlock()
+	return true
+}
+
+// call は fn を実行します。パニックした場合はスタックを記録し、プロセスを落とさずにエラーとして返します。
+func (s *Scheduler) call(ctx context.Context, name string, fn TaskFunc) (panicked bool, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			s.logger.Error("タスク実行中にパニックが発生しました",
+				zap.String("name", name),
+				zap.Any("panic", rec),
+				zap.ByteString("stack", debug.Stack()))
+			panicked = true
+			err = fmt.Errorf("パニックが発生しました: %v", rec)
+		}
+	}()
+	return false, fn(ctx)
+}
+
+// Statuses は登録順にタスクの実行状況を返します。
+func (s *Scheduler) Statuses() []TaskStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	statuses := make([]TaskStatus, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		st := t.status
+		st.NextRun = t.job.NextRun()
+		st.InWindow = s.calendar.InWindow(t.opts.Window, now)
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// NewScheduler は calendar のタイムゾーン（日本時間）で cron 式を解釈するスケジューラを作ります。
 func NewScheduler(
-	discord *discordgo.Session, 
+	discord *discordgo.Session,
 	logger *zap.Logger,
 	summaryService *SummaryService,
+	calendar *market.Calendar,
 ) *Scheduler {
-	s := gocron.NewScheduler(time.UTC)
+	s := gocron.NewScheduler(calendar.Location())
 	return &Scheduler{
 		Scheduler:      s,
+		discord:        discord,
 		logger:         logger,
 		summaryService: summaryService,
+		calendar:       calendar,
+		ctx:            context.Background(),
 	}
 }
 
-func (s *Scheduler) Start() {
+// Start はスケジューラを起動します。ctx はスケジューラ内部のタスクに渡され、
+// キャンセルすると実行中の要約ジョブなども中断します。
+func (s *Scheduler) Start(ctx context.Context) {
+	s.ctx = ctx
 	s.Scheduler.StartAsync()
 	s.logger.Info("スケジューラを起動しました")
 }
 
+// Shutdown は新しい実行の開始を止め、実行中のタスクが終わるか ctx が終了するまで待ちます。
+// 待ちきれなかった場合は ctx.Err() を返します。
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		// gocron の Stop も実行中のジョブを待つため、期限を守れるよう別の goroutine で呼ぶ
+		s.Scheduler.Stop()
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.logger.Info("スケジューラを停止しました")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitForShutdown は SIGINT または SIGTERM を受信するまで待ちます。
 func WaitForShutdown(logger *zap.Logger) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	logger.Info("シャットダウン信号を受信しました")
+	sig := <-quit
+	signal.Stop(quit)
+	logger.Info("シャットダウン信号を受信しました", zap.String("signal", sig.String()))
 }
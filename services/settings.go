@@ -0,0 +1,164 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"bot/models"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// SettingsStore は models.SiteSetting をメモリにキャッシュし、変更時にDBへ保存します。
+type SettingsStore struct {
+	db       *gorm.DB
+	logger   *zap.Logger
+	mu       sync.RWMutex
+	settings map[string]models.SiteSetting
+	filters  map[string]string // 設定ファイル由来のデフォルトフィルタ
+}
+
+func NewSettingsStore(db *gorm.DB, logger *zap.Logger) (*SettingsStore, error) {
+	var rows []models.SiteSetting
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("サイト設定の読み込みに失敗しました: %w", err)
+	}
+
+	settings := make(map[string]models.SiteSetting, len(rows))
+	for _, row := range rows {
+		settings[row.Site] = row
+	}
+	return &SettingsStore{
+		db:       db,
+		logger:   logger,
+		settings: settings,
+		filters:  make(map[string]string),
+	}, nil
+}
+
+// Enabled はサイトが有効かどうかを返します。未設定のサイトは有効として扱います。
+func (s *SettingsStore) Enabled(site string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st, ok := s.settings[site]
+	return !ok || st.Enabled
+}
+
+// SetEnabled はサイトの有効/無効を切り替えて保存します。
+func (s *SettingsStore) SetEnabled(site string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.settings[site]
+	if !ok {
+		st = models.SiteSetting{Site: site}
+	}
+	st.Enabled = enabled
+	if err := s.db.Save(&st).Error; err != nil {
+		return fmt.Errorf("サイト設定の保存に失敗しました: %w", err)
+	}
+	s.settings[site] = st
+
+	s.logger.Info("サイト設定を更新しました",
+		zap.String("site", site),
+		zap.Bool("enabled", enabled))
+	return nil
+}
+
+// Toggle はサイトの有効/無効を反転し、変更後の状態を返します。
+func (s *SettingsStore) Toggle(site string) (bool, error) {
+	enabled := !s.Enabled(site)
+	if err := s.SetEnabled(site, enabled); err != nil {
+		return !enabled, err
+	}
+	return enabled, nil
+}
+
+// SetDefaultFilter は /set filter で上書きされていない場合に使うフィルタを登録します。
+func (s *SettingsStore) SetDefaultFilter(site, filter string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters[site] = filter
+}
+
+// Filter はサイトの現在のフィルタを返します。スケジュール実行のたびに呼び出してください。
+func (s *SettingsStore) Filter(site string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if st, ok := s.settings[site]; ok && st.Filter != nil {
+		return *st.Filter
+	}
+	return s.filters[site]
+}
+
+// ValidateFilter はフィルタがURLクエリ文字列として解釈できるか検証し、
+// 先頭の "?" を取り除いた値を返します。
+func ValidateFilter(filter string) (string, error) {
+	filter = strings.TrimPrefix(strings.TrimSpace(filter), "?")
+	if strings.ContainsAny(filter, " \t\r\n#") {
+		return "", fmt.Errorf("フィルタに空白や # は使用できません")
+	}
+	if _, err := url.ParseQuery(filter); err != nil {
+		return "", fmt.Errorf("フィルタをクエリ文字列として解析できません: %w", err)
+	}
+	return filter, nil
+}
+
+// SetFilter はサイトのフィルタを検証して保存し、変更前の値を返します。
+func (s *SettingsStore) SetFilter(site, filter, updatedBy string) (string, error) {
+	filter, err := ValidateFilter(filter)
+	if err != nil {
+		return "", err
+	}
+	old := s.Filter(site)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.settings[site]
+	if !ok {
+		st = models.SiteSetting{Site: site, Enabled: true}
+	}
+	st.Filter = &filter
+	st.UpdatedBy = updatedBy
+	if err := s.db.Save(&st).Error; err != nil {
+		return "", fmt.Errorf("フィルタの保存に失敗しました: %w", err)
+	}
+	s.settings[site] = st
+
+	s.logger.Info("フィルタを更新しました",
+		zap.String("site", site),
+		zap.String("old", old),
+		zap.String("new", filter),
+		zap.String("user", updatedBy))
+	return old, nil
+}
+
+// ResetFilter は /set filter による上書きを取り消し、設定ファイルのフィルタに戻します。変更前の値を返します。
+func (s *SettingsStore) ResetFilter(site, updatedBy string) (string, error) {
+	old := s.Filter(site)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.settings[site]
+	if !ok || st.Filter == nil {
+		return old, nil
+	}
+	st.Filter = nil
+	st.UpdatedBy = updatedBy
+	if err := s.db.Save(&st).Error; err != nil {
+		return "", fmt.Errorf("フィルタの保存に失敗しました: %w", err)
+	}
+	s.settings[site] = st
+
+	s.logger.Info("フィルタを設定ファイルの値に戻しました",
+		zap.String("site", site),
+		zap.String("old", old),
+		zap.String("new", s.filters[site]),
+		zap.String("user", updatedBy))
+	return old, nil
+}
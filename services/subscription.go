@@ -0,0 +1,242 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"bot/matcher"
+	"bot/models"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultMaxSubscriptions = 50
+	maxPatternLength        = 200
+	minKeywordLength        = 2
+)
+
+var (
+	ErrSubscriptionExists   = errors.New("同じ条件の購読が既に登録されています")
+	ErrSubscriptionNotFound = errors.New("購読が見つかりません")
+	ErrSubscriptionLimit    = errors.New("購読数の上限に達しています")
+)
+
+// stockCodePattern は4桁の銘柄コード（2024年以降の英字を含むコードを含む）に一致します。
+var stockCodePattern = regexp.MustCompile(`^[0-9][0-9A-Z][0-9][0-9A-Z]$`)
+
+// SubscriptionService は購読の登録と記事とのマッチングを行います。
+// キーワードと銘柄コードは Aho-Corasick で一括照合し、正規表現のみ個別に評価します。
+type SubscriptionService struct {
+	db     *gorm.DB
+	logger *zap.Logger
+
+	mu    sync.RWMutex
+	index *subscriptionIndex
+}
+
+type subscriptionIndex struct {
+	// literals は matcher のパターン順に対応する購読
+	literals []models.Subscription
+	matcher  *matcher.Matcher
+	codes    map[string][]models.Subscription
+	regexes  []compiledSubscription
+}
+
+type compiledSubscription struct {
+	sub models.Subscription
+	re  *regexp.Regexp
+}
+
+func NewSubscriptionService(db *gorm.DB, logger *zap.Logger) (*SubscriptionService, error) {
+	s := &SubscriptionService{db: db, logger: logger}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload はDBから購読を読み直して照合用のインデックスを作り直します。
+func (s *SubscriptionService) reload() error {
+	var subs []models.Subscription
+	if err := s.db.Find(&subs).Error; err != nil {
+		return fmt.Errorf("購読の読み込みに失敗しました: %w", err)
+	}
+
+	idx := &subscriptionIndex{codes: make(map[string][]models.Subscription)}
+	var patterns []string
+	for _, sub := range subs {
+		switch sub.Kind {
+		case models.SubscriptionRegex:
+			re, err := regexp.Compile(sub.Pattern)
+			if err != nil {
+				s.logger.Warn("不正な正規表現の購読をスキップします", zap.Uint("id", sub.ID), zap.Error(err))
+				continue
+			}
+			idx.regexes = append(idx.regexes, compiledSubscription{sub: sub, re: re})
+		case models.SubscriptionCode:
+			idx.codes[sub.Pattern] = append(idx.codes[sub.Pattern], sub)
+			fallthrough
+		default:
+			idx.literals = append(idx.literals, sub)
+			patterns = append(patterns, sub.Pattern)
+		}
+	}
+	idx.matcher = matcher.New(patterns)
+
+	s.mu.Lock()
+	s.index = idx
+	s.mu.Unlock()
+
+	s.logger.Debug("購読インデックスを再構築しました",
+		zap.Int("literals", len(idx.literals)),
+		zap.Int("regexes", len(idx.regexes)))
+	return nil
+}
+
+// ValidateSubscription は購読条件を検証し、保存用に整形したパターンを返します。
+func ValidateSubscription(kind, pattern string) (string, error) {
+	pattern = strings.TrimSpace(pattern)
+	if utf8.RuneCountInString(pattern) > maxPatternLength {
+		return "", fmt.Errorf("条件は%d文字以内で指定してください", maxPatternLength)
+	}
+
+	switch kind {
+	case models.SubscriptionKeyword:
+		if utf8.RuneCountInString(pattern) < minKeywordLength {
+			return "", fmt.Errorf("キーワードは%d文字以上で指定してください", minKeywordLength)
+		}
+		return pattern, nil
+	case models.SubscriptionRegex:
+		if pattern == "" {
+			return "", fmt.Errorf("正規表現を指定してください")
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return "", fmt.Errorf("正規表現が不正です: %w", err)
+		}
+		return pattern, nil
+	case models.SubscriptionCode:
+		return NormalizeStockCode(pattern)
+	}
+	return "", fmt.Errorf("不明な種類です: %s", kind)
+}
+
+// NormalizeStockCode は全角・小文字を含む入力を "7203" や "130A" の形式に揃えて検証します。
+func NormalizeStockCode(code string) (string, error) {
+	code = strings.ToUpper(matcher.Normalize(strings.TrimSpace(code)))
+	if !stockCodePattern.MatchString(code) {
+		return "", fmt.Errorf("銘柄コードは4桁で指定してください (例: 7203, 130A)")
+	}
+	return code, nil
+}
+
+// Add は購読を登録します。sub.Pattern は ValidateSubscription で整形されます。
+func (s *SubscriptionService) Add(sub *models.Subscription) error {
+	pattern, err := ValidateSubscription(sub.Kind, sub.Pattern)
+	if err != nil {
+		return err
+	}
+	sub.Pattern = pattern
+
+	limit := viper.GetInt("subscriptions.max_per_user")
+	if limit <= 0 {
+		limit = defaultMaxSubscriptions
+	}
+	var count int64
+	if err := s.db.Model(&models.Subscription{}).Where("user_id = ?", sub.UserID).Count(&count).Error; err != nil {
+		return fmt.Errorf("購読数の取得に失敗しました: %w", err)
+	}
+	if count >= int64(limit) {
+		return fmt.Errorf("%w (%d件)", ErrSubscriptionLimit, limit)
+	}
+
+	var exists int64
+	if err := s.db.Model(&models.Subscription{}).
+		Where("user_id = ? AND guild_id = ? AND kind = ? AND pattern = ?", sub.UserID, sub.GuildID, sub.Kind, sub.Pattern).
+		Count(&exists).Error; err != nil {
+		return fmt.Errorf("購読の確認に失敗しました: %w", err)
+	}
+	if exists > 0 {
+		return ErrSubscriptionExists
+	}
+
+	if err := s.db.Create(sub).Error; err != nil {
+		return fmt.Errorf("購読の保存に失敗しました: %w", err)
+	}
+	return s.reload()
+}
+
+// Remove は本人の購読を削除します。
+func (s *SubscriptionService) Remove(userID, guildID string, id uint) error {
+	res := s.db.Where("id = ? AND user_id = ? AND guild_id = ?", id, userID, guildID).Delete(&models.Subscription{})
+	if res.Error != nil {
+		return fmt.Errorf("購読の削除に失敗しました: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrSubscriptionNotFound
+	}
+	return s.reload()
+}
+
+// List はユーザーのサーバー内での購読を登録順に返します。
+func (s *SubscriptionService) List(userID, guildID string) ([]models.Subscription, error) {
+	var subs []models.Subscription
+	if err := s.db.Where("user_id = ? AND guild_id = ?", userID, guildID).Order("id").Find(&subs).Error; err != nil {
+		return nil, fmt.Errorf("購読の取得に失敗しました: %w", err)
+	}
+	return subs, nil
+}
+
+// Match は記事のテキストと関連付けられた銘柄コードに一致する購読を返します。同じ購読は1度だけ含まれます。
+func (s *SubscriptionService) Match(text string, codes ...string) []models.Subscription {
+	s.mu.RLock()
+	idx := s.index
+	s.mu.RUnlock()
+
+	seen := make(map[uint]bool)
+	var matched []models.Subscription
+	add := func(sub models.Subscription) {
+		if !seen[sub.ID] {
+			seen[sub.ID] = true
+			matched = append(matched, sub)
+		}
+	}
+
+	for _, code := range codes {
+		for _, sub := range idx.codes[strings.ToUpper(code)] {
+			add(sub)
+		}
+	}
+
+	normalized := matcher.Normalize(text)
+	for _, hit := range idx.matcher.FindAll(normalized) {
+		sub := idx.literals[hit.Pattern]
+		// 銘柄コードは "17203" のような別の数字列の一部に一致させない
+		if sub.Kind == models.SubscriptionCode && !isCodeBoundary(normalized, hit.Start, hit.End) {
+			continue
+		}
+		add(sub)
+	}
+
+	for _, c := range idx.regexes {
+		if !seen[c.sub.ID] && c.re.MatchString(text) {
+			add(c.sub)
+		}
+	}
+	return matched
+}
+
+func isCodeBoundary(text string, start, end int) bool {
+	return (start == 0 || !isASCIIAlnum(text[start-1])) && (end == len(text) || !isASCIIAlnum(text[end]))
+}
+
+// isASCIIAlnum は matcher.Normalize 後の文字列の英数字(小文字)かどうかを返します。
+func isASCIIAlnum(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z'
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"sort"
+	"testing"
+
+	"bot/models"
+
+	"go.uber.org/zap"
+)
+
+func newTestSubscriptions(t *testing.T, subs ...models.Subscription) *SubscriptionService {
+	t.Helper()
+	s, err := NewSubscriptionService(openTestDB(t), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := range subs {
+		sub := subs[idx]
+		sub.UserID = "u1"
+		sub.GuildID = "g1"
+		if err := s.Add(&sub); err != nil {
+			t.Fatalf("Add(%s %q): %v", sub.Kind, sub.Pattern, err)
+		}
+	}
+	return s
+}
+
+func matchedPatterns(subs []models.Subscription) []string {
+	var patterns []string
+	for _, sub := range subs {
+		patterns = append(patterns, sub.Kind+":"+sub.Pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+func TestSubscriptionMatch(t *testing.T) {
+	s := newTestSubscriptions(t,
+		models.Subscription{Kind: models.SubscriptionKeyword, Pattern: "上方修正"},
+		models.Subscription{Kind: models.SubscriptionKeyword, Pattern: "修正"},
+		models.Subscription{Kind: models.SubscriptionKeyword, Pattern: "ＥＶ"},
+		models.Subscription{Kind: models.SubscriptionRegex, Pattern: `増配|自社株買い`},
+		models.Subscription{Kind: models.SubscriptionRegex, Pattern: `^\[決算\]`},
+		models.Subscription{Kind: models.SubscriptionCode, Pattern: "７２０３"},
+		models.Subscription{Kind: models.SubscriptionCode, Pattern: "130a"},
+	)
+
+	tests := []struct {
+		name  string
+		text  string
+		codes []string
+		want  []string
+	}{
+		{"overlapping keywords", "通期業績予想の上方修正", nil, []string{"keyword:上方修正", "keyword:修正"}},
+		{"width and case", "新型Ev車を発表", nil, []string{"keyword:ＥＶ"}},
+		{"regex", "増配を発表", nil, []string{"regex:増配|自社株買い"}},
+		{"anchored regex", "[決算] 7203 トヨタ", nil, []string{"code:7203", "regex:^\\[決算\\]"}},
+		{"anchored regex does not match midway", "速報 [決算] トヨタ", nil, nil},
+		{"code in text", "トヨタ(7203)が発表", nil, []string{"code:7203"}},
+		{"code with letter in text", "新規上場の130Aが急騰", nil, []string{"code:130A"}},
+		{"full-width code in text", "トヨタ（７２０３）", nil, []string{"code:7203"}},
+		{"code inside longer number", "受注額172030円", nil, nil},
+		{"code inside alphanumeric", "型番X7203Y", nil, nil},
+		{"code from extraction", "トヨタ自動車の決算", []string{"7203"}, []string{"code:7203"}},
+		{"lower-case extracted code", "新規上場", []string{"130a"}, []string{"code:130A"}},
+		{"same subscription once", "7203 トヨタ", []string{"7203"}, []string{"code:7203"}},
+		{"no match", "日経平均は続落", []string{"6758"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchedPatterns(s.Match(tt.text, tt.codes...))
+			if len(got) != len(tt.want) {
+				t.Fatalf("Match(%q, %v) = %v, want %v", tt.text, tt.codes, got, tt.want)
+			}
+			for idx := range got {
+				if got[idx] != tt.want[idx] {
+					t.Fatalf("Match(%q, %v) = %v, want %v", tt.text, tt.codes, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSubscription(t *testing.T) {
+	tests := []struct {
+		kind, pattern, want string
+		ok                  bool
+	}{
+		{models.SubscriptionKeyword, "  決算  ", "決算", true},
+		{models.SubscriptionKeyword, "株", "", false},
+		{models.SubscriptionRegex, "(", "", false},
+		{models.SubscriptionRegex, "上方.*修正", "上方.*修正", true},
+		{models.SubscriptionCode, "７２０３", "7203", true},
+		{models.SubscriptionCode, "130a", "130A", true},
+		{models.SubscriptionCode, "72031", "", false},
+		{models.SubscriptionCode, "A203", "", false},
+		{"unknown", "x", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ValidateSubscription(tt.kind, tt.pattern)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ValidateSubscription(%s, %q) = %q, %v", tt.kind, tt.pattern, got, err)
+		}
+	}
+}
@@ -1,70 +1,52 @@
 package services
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"time"
+	"strings"
 
 	"go.uber.org/zap"
+	"bot/ai"
 	"bot/config"
+	"bot/models"
 	"gorm.io/gorm"
 )
 
 type SummaryService struct {
-	cfg    *config.AIConfig
-	logger *zap.Logger
-	client *http.Client
-	db     *gorm.DB
+	cfg      *config.AIConfig
+	logger   *zap.Logger
+	provider ai.Provider
+	bodies   *BodyFetcher // 記事本文の取得用
+	db       *gorm.DB
 }
 
-type Article struct {
-	gorm.Model
-	Site        string
-	Title       string    
-	URL         string    
-	Hash        string    
-	Content     string    
-	Body        string    
-	Summary     string    
-	Category    string    
-	PublishedAt time.Time
-}
-
-type DeepseekRequest struct {
-	Model       string        `json:"model"`
-	Messages    []Message     `json:"messages"`
-	Temperature float64       `json:"temperature"`
-	MaxTokens   int           `json:"max_tokens"`
-}
-
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
+// ErrArticleNotFound は指定URLの記事がデータベースにない場合に返されます。
+var ErrArticleNotFound = errors.New("記事がデータベースに見つかりません")
 
-type DeepseekResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
+func NewSummaryService(cfg *config.AIConfig, logger *zap.Logger, db *gorm.DB, bodies *BodyFetcher) (*SummaryService, error) {
+	provider, err := ai.NewProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewSummaryServiceWithProvider(provider, cfg, logger, db, bodies), nil
 }
 
-func NewSummaryService(cfg *config.AIConfig, logger *zap.Logger, db *gorm.DB) *SummaryService {
+// NewSummaryServiceWithProvider は任意のプロバイダーで SummaryService を生成します。
+// テストでは ai.Fake を渡してください。
+func NewSummaryServiceWithProvider(provider ai.Provider, cfg *config.AIConfig, logger *zap.Logger, db *gorm.DB, bodies *BodyFetcher) *SummaryService {
 	return &SummaryService{
-		cfg:    cfg,
-		logger: logger,
-		db:     db,
-		client: &http.Client{
-			Timeout: time.Duration(cfg.Timeout) * time.Millisecond,
-		},
+		cfg:      cfg,
+		logger:   logger,
+		db:       db,
+		provider: provider,
+		bodies:   bodies,
 	}
 }
 
-func (s *SummaryService) GenerateSummary(ctx context.Context, content string) (string, error) {
+// GenerateSummary は複数記事をまとめて要約し、記事ごとの要約とダイジェストを
+// JSON 形式で返すようにモデルへ依頼します。content の各記事は [ID:n] で始めてください。
+func (s *SummaryService) GenerateSummary(ctx context.Context, content string, maxTokens int) (string, error) {
 	prompt := fmt.Sprintf(`あなたは上場企業の決算ニュース要約アシスタントです。  
 これから、過去6時間に収集されたニュース記事をまとめレポートを作成します。  
 
@@ -76,78 +58,102 @@ func (s *SummaryService) GenerateSummary(ctx context.Context, content string) (s
 2. **6時間ダイジェスト**  
    全記事の要約を踏まえ、最後に「6時間のまとめ」として、注目すべきトレンド、関心度が高いテーマ、緊急度の高いニュースを3～5行でレポートしてください
 
+3. **出力形式**  
+   次の形式のJSONのみを出力してください。id は記事先頭の [ID:n] の数値です。  
+   {"summaries":[{"id":1,"summary":"記事の要約"}],"digest":"6時間のまとめ"}
+
 
 【記事本文】
 %s`, content)
 
-	requestBody := DeepseekRequest{
-		Model: s.cfg.Model,
-		Messages: []Message{
+	return s.complete(ctx, prompt, maxTokens)
+}
+
+// SummarizeArticle は1記事分の要約を生成します。
+func (s *SummaryService) SummarizeArticle(ctx context.Context, title, category, body string) (string, error) {
+	prompt := fmt.Sprintf(`あなたは上場企業の決算ニュース要約アシスタントです。
+次の記事を読んで、2～3 文（日本語200文字以内）で要点をまとめてください。
+- 売上高、経常利益、増配・減配、最高益・赤字転落など“数字”と“変化”を必ず含めること。
+- カテゴリが「決算」なら業績全体、「修正」なら修正前後の差分を意識すること。
+- 要約本文のみを出力すること。
+
+【タイトル】
+%s
+
+【カテゴリ】
+%s
+
+【記事本文】
+%s`, title, category, body)
+
+	return s.complete(ctx, prompt, 300)
+}
+
+// complete はプロンプトをAPIに送り、最初の応答を返します。
+func (s *SummaryService) complete(ctx context.Context, prompt string, maxTokens int) (string, error) {
+	return s.provider.Complete(ctx, ai.Request{
+		Messages: []ai.Message{
 			{
 				Role:    "user",
 				Content: prompt,
 			},
 		},
 		Temperature: 0.7,
-		MaxTokens:   500,
-	}
+		MaxTokens:   maxTokens,
+	})
+}
 
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("リクエストのマーシャリングに失敗しました: %w", err)
-	}
+// FindArticleByURL は URL で記事を検索します。末尾のスラッシュの有無は区別しません。
+func (s *SummaryService) FindArticleByURL(ctx context.Context, rawURL string) (*models.Article, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	candidates := []string{rawURL, strings.TrimSuffix(rawURL, "/"), strings.TrimSuffix(rawURL, "/") + "/"}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", s.cfg.Endpoint, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", fmt.Errorf("リクエストの作成に失敗しました: %w", err)
+	var article models.Article
+	err := s.db.WithContext(ctx).Where("url IN ?", candidates).First(&article).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrArticleNotFound
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.cfg.APIKey)
-
-	resp, err := s.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("APIリクエストに失敗しました: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("APIがエラーステータスを返しました: %s", resp.Status)
+		return nil, fmt.Errorf("記事の検索に失敗しました: %w", err)
 	}
+	return &article, nil
+}
 
-	var response DeepseekResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", fmt.Errorf("レスポンスの解析に失敗しました: %w", err)
+// GenerateAndStoreSummary は記事の要約を生成して保存します。
+// Body が空の場合は BodyFetcher で記事ページから本文を取得して先に保存します。
+// 既に要約がある場合はAPIを呼ばずに true を返します。
+func (s *SummaryService) GenerateAndStoreSummary(ctx context.Context, article *models.Article) (cached bool, err error) {
+	if article.Summary != "" {
+		return true, nil
 	}
 
-	if len(response.Choices) == 0 {
-		return "", fmt.Errorf("有効な要約が生成されませんでした")
+	if article.Body == "" {
+		// ワーカーと同じドメインごとの間隔制限で取得し、本文の保存もまとめて行う
+		if err := s.bodies.FetchNow(ctx, article); err != nil {
+			return false, fmt.Errorf("本文の取得に失敗しました: %w", err)
+		}
 	}
 
-	return response.Choices[0].Message.Content, nil
-}
-
-func (s *SummaryService) GenerateAndStoreSummary(ctx context.Context, articleID int, content string) error {
-	summary, err := s.GenerateSummary(ctx, content)
+	summary, err := s.SummarizeArticle(ctx, article.Title, article.Category, article.Body)
 	if err != nil {
 		s.logger.Error("要約生成に失敗しました",
-			zap.Int("article_id", articleID),
+			zap.Uint("article_id", article.ID),
 			zap.Error(err))
-		return fmt.Errorf("要約生成に失敗しました: %w", err)
+		return false, fmt.Errorf("要約生成に失敗しました: %w", err)
 	}
+	article.Summary = strings.TrimSpace(summary)
 
-	if err := s.db.WithContext(ctx).Model(&Article{}).
-		Where("id = ?", articleID).
-		Update("summary", summary).Error; err != nil {
+	if err := s.db.WithContext(ctx).Model(article).
+		Update("summary", article.Summary).Error; err != nil {
 		s.logger.Error("要約の保存に失敗しました",
-			zap.Int("article_id", articleID),
+			zap.Uint("article_id", article.ID),
 			zap.Error(err))
-		return fmt.Errorf("要約の保存に失敗しました: %w", err)
+		return false, fmt.Errorf("要約の保存に失敗しました: %w", err)
 	}
-	
+
 	s.logger.Info("要約の生成と保存が完了しました",
-		zap.Int("article_id", articleID),
-		zap.String("summary", summary))
+		zap.Uint("article_id", article.ID),
+		zap.String("summary", article.Summary))
 
-	return nil
+	return false, nil
 }
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"bot/matcher"
+	"bot/models"
+
+	"go.uber.org/zap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// minCompanyNameLength より短い社名は誤検出が多いため照合に使いません。
+const minCompanyNameLength = 2
+
+// inlineCodePattern は "(7203)" "＜130A＞" のように括弧で囲まれた銘柄コードです。
+// matcher.Normalize 後の文字列に対して使うため、全角括弧と英大文字は考慮不要です。
+var inlineCodePattern = regexp.MustCompile(`[(<\[]\s*([0-9][0-9a-z][0-9][0-9a-z])\s*[)>\]]`)
+
+// companySuffixes は社名照合時に取り除く表記です。
+var companySuffixes = []string{"株式会社", "(株)"}
+
+// Ticker は記事から抽出した銘柄コードです。
+type Ticker struct {
+	Code   string
+	Source string
+}
+
+// TickerService は記事から銘柄コードを抽出し、article_tickers に保存します。
+type TickerService struct {
+	db     *gorm.DB
+	logger *zap.Logger
+
+	mu        sync.RWMutex
+	companies map[string]bool
+	names     *matcher.Matcher
+	nameCodes []string // names のパターン順に対応する銘柄コード
+	// loaded は読み込んだ時点の銘柄マスタの件数と最終更新時刻。ReloadIfChanged で変更の検出に使う
+	loaded companiesVersion
+}
+
+type companiesVersion struct {
+	count   int64
+	updated time.Time
+}
+
+func NewTickerService(db *gorm.DB, logger *zap.Logger) (*TickerService, error) {
+	t := &TickerService{db: db, logger: logger}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload は銘柄マスタを読み直して社名の照合器を作り直します。
+func (t *TickerService) Reload() error {
+	version, err := t.companiesVersion(context.Background())
+	if err != nil {
+		return err
+	}
+	var companies []models.Company
+	if err := t.db.Find(&companies).Error; err != nil {
+		return fmt.Errorf("銘柄マスタの読み込みに失敗しました: %w", err)
+	}
+
+	codes := make(map[string]bool, len(companies))
+	var patterns, nameCodes []string
+	for _, c := range companies {
+		codes[c.Code] = true
+		name := matcher.Normalize(c.Name)
+		for _, suffix := range companySuffixes {
+			name = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(name, suffix), suffix))
+		}
+		if utf8.RuneCountInString(name) < minCompanyNameLength {
+			continue
+		}
+		patterns = append(patterns, name)
+		nameCodes = append(nameCodes, c.Code)
+	}
+
+	t.mu.Lock()
+	t.companies = codes
+	t.names = matcher.New(patterns)
+	t.nameCodes = nameCodes
+	t.loaded = version
+	t.mu.Unlock()
+
+	t.logger.Debug("銘柄マスタを読み込みました", zap.Int("companies", len(companies)))
+	return nil
+}
+
+// ReloadIfChanged は銘柄マスタが読み込み後に変更されていれば読み直し、読み直したかどうかを返します。
+// -import-companies は別プロセスで実行されるため、稼働中の Bot はこれを定期的に呼んで取り込み結果を反映します。
+func (t *TickerService) ReloadIfChanged(ctx context.Context) (bool, error) {
+	version, err := t.companiesVersion(ctx)
+	if err != nil {
+		return false, err
+	}
+	t.mu.RLock()
+	loaded := t.loaded
+	t.mu.RUnlock()
+	if version.count == loaded.count && version.updated.Equal(loaded.updated) {
+		return false, nil
+	}
+	if err := t.Reload(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// companiesVersion は銘柄マスタの件数と最終更新時刻を返します。取り込みのたびに updated_at が更新されます。
+func (t *TickerService) companiesVersion(ctx context.Context) (companiesVersion, error) {
+	var v companiesVersion
+	if err := t.db.WithContext(ctx).Model(&models.Company{}).Count(&v.count).Error; err != nil {
+		return v, fmt.Errorf("銘柄マスタの件数の取得に失敗しました: %w", err)
+	}
+	var latest []models.Company
+	if err := t.db.WithContext(ctx).Select("code", "updated_at").Order("updated_at DESC").Limit(1).Find(&latest).Error; err != nil {
+		return v, fmt.Errorf("銘柄マスタの更新時刻の取得に失敗しました: %w", err)
+	}
+	if len(latest) > 0 {
+		v.updated = latest[0].UpdatedAt
+	}
+	return v, nil
+}
+
+// Extract はテキストから銘柄コードを抽出します。siteCode は取得元が付与したコードで、空でも構いません。
+// 銘柄マスタが取り込まれている場合、マスタにないコードは除外します。
+func (t *TickerService) Extract(text, siteCode string) []Ticker {
+	t.mu.RLock()
+	companies, names, nameCodes := t.companies, t.names, t.nameCodes
+	t.mu.RUnlock()
+
+	var tickers []Ticker
+	seen := make(map[string]bool)
+	add := func(code, source string) {
+		code = strings.ToUpper(code)
+		if seen[code] {
+			return
+		}
+		if source != models.TickerFromSite && len(companies) > 0 && !companies[code] {
+			return
+		}
+		seen[code] = true
+		tickers = append(tickers, Ticker{Code: code, Source: source})
+	}
+
+	if siteCode != "" {
+		add(siteCode, models.TickerFromSite)
+	}
+
+	normalized := matcher.Normalize(text)
+	for _, m := range inlineCodePattern.FindAllStringSubmatch(normalized, -1) {
+		add(m[1], models.TickerFromCode)
+	}
+	for _, hit := range names.FindAll(normalized) {
+		// "nec" が "connect" の一部に一致するような、英数字の単語の途中での一致は除く
+		if !isNameBoundary(normalized, hit.Start, hit.End) {
+			continue
+		}
+		add(nameCodes[hit.Pattern], models.TickerFromName)
+	}
+	return tickers
+}
+
+// isNameBoundary は社名の一致箇所が英数字の単語の途中でないかを返します。
+// 英数字で始まる(終わる)社名のみ前(後)の文字を確認するため、日本語の社名は従来どおり部分一致します。
+func isNameBoundary(text string, start, end int) bool {
+	if isASCIIAlnum(text[start]) && start > 0 && isASCIIAlnum(text[start-1]) {
+		return false
+	}
+	if isASCIIAlnum(text[end-1]) && end < len(text) && isASCIIAlnum(text[end]) {
+		return false
+	}
+	return true
+}
+
+// ExtractAndStore は記事のタイトル・本文から銘柄を抽出して保存し、抽出したコードを返します。
+// 既に保存済みの関連はそのまま残すため、本文取得後などに何度呼んでも構いません。
+func (t *TickerService) ExtractAndStore(ctx context.Context, article *models.Article) ([]string, error) {
+	tickers := t.Extract(article.Title+"\n"+article.Body, article.StockCode)
+	if len(tickers) == 0 {
+		return nil, nil
+	}
+
+	rows := make([]models.ArticleTicker, 0, len(tickers))
+	codes := make([]string, 0, len(tickers))
+	for _, tk := range tickers {
+		rows = append(rows, models.ArticleTicker{ArticleID: article.ID, StockCode: tk.Code, Source: tk.Source})
+		codes = append(codes, tk.Code)
+	}
+	if err := t.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+		return codes, fmt.Errorf("銘柄の関連付けに失敗しました: %w", err)
+	}
+	return codes, nil
+}
+
+// Backfill は保存済みのすべての記事に対して抽出をやり直します。
+func (t *TickerService) Backfill(ctx context.Context) (int, error) {
+	var (
+		articles []models.Article
+		linked   int
+	)
+	err := t.db.WithContext(ctx).Model(&models.Article{}).
+		Select("id", "title", "body", "stock_code").
+		FindInBatches(&articles, 500, func(tx *gorm.DB, batch int) error {
+			for idx := range articles {
+				codes, err := t.ExtractAndStore(ctx, &articles[idx])
+				if err != nil {
+					return err
+				}
+				if len(codes) > 0 {
+					linked++
+				}
+			}
+			return nil
+		}).Error
+	return linked, err
+}
+
+// ImportCompaniesCSV は銘柄マスタを CSV から取り込み、取り込んだ件数を返します。
+// 1行目はヘッダーで、"コード"/"code"、"銘柄名"/"name"、"市場・商品区分"/"market" の列を使います。
+// JPX の上場銘柄一覧のような Shift_JIS のファイルもそのまま読み込めます。
+func ImportCompaniesCSV(db *gorm.DB, r io.Reader) (int, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(raw) {
+		if raw, _, err = transform.Bytes(japanese.ShiftJIS.NewDecoder(), raw); err != nil {
+			return 0, fmt.Errorf("Shift_JIS からの変換に失敗しました: %w", err)
+		}
+	}
+
+	reader := csv.NewReader(bytes.NewReader(raw))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("ヘッダーの読み込みに失敗しました: %w", err)
+	}
+	codeCol, nameCol, marketCol := -1, -1, -1
+	for idx, col := range header {
+		switch strings.ToLower(strings.TrimSpace(col)) {
+		case "コード", "code":
+			codeCol = idx
+		case "銘柄名", "name":
+			nameCol = idx
+		case "市場・商品区分", "market":
+			marketCol = idx
+		}
+	}
+	if codeCol < 0 || nameCol < 0 {
+		return 0, errors.New("コード列と銘柄名列が必要です")
+	}
+
+	var companies []models.Company
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("CSVの読み込みに失敗しました: %w", err)
+		}
+		if codeCol >= len(record) || nameCol >= len(record) {
+			continue
+		}
+		// ETF 等を含む一覧でも4桁の銘柄コードのみ取り込む
+		code, err := NormalizeStockCode(record[codeCol])
+		if err != nil {
+			continue
+		}
+		c := models.Company{Code: code, Name: strings.TrimSpace(record[nameCol])}
+		if marketCol >= 0 && marketCol < len(record) {
+			c.Market = strings.TrimSpace(record[marketCol])
+		}
+		companies = append(companies, c)
+	}
+	if len(companies) == 0 {
+		return 0, errors.New("取り込める銘柄がありません")
+	}
+
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "code"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "market", "updated_at"}),
+	}).CreateInBatches(&companies, 500).Error
+	if err != nil {
+		return 0, fmt.Errorf("銘柄マスタの保存に失敗しました: %w", err)
+	}
+	return len(companies), nil
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func tickerCodes(tickers []Ticker) []string {
+	var codes []string
+	for _, t := range tickers {
+		codes = append(codes, t.Code)
+	}
+	return codes
+}
+
+// TestReloadIfChanged は別プロセスでの -import-companies を、稼働中のサービスが読み込み直すことを確認します。
+func TestReloadIfChanged(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	tickers, err := NewTickerService(db, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const text = "ソニーグループが自社株買いを発表"
+	if got := tickers.Extract(text, ""); len(got) != 0 {
+		t.Fatalf("before import: Extract = %v, want none", got)
+	}
+	if changed, err := tickers.ReloadIfChanged(ctx); err != nil || changed {
+		t.Fatalf("unchanged master: ReloadIfChanged = %v, %v", changed, err)
+	}
+
+	if _, err := ImportCompaniesCSV(db, strings.NewReader("コード,銘柄名,市場・商品区分\n6758,ソニーグループ,プライム\n")); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := tickers.ReloadIfChanged(ctx); err != nil || !changed {
+		t.Fatalf("after import: ReloadIfChanged = %v, %v", changed, err)
+	}
+	if got := tickerCodes(tickers.Extract(text, "")); len(got) != 1 || got[0] != "6758" {
+		t.Fatalf("after import: Extract = %v, want [6758]", got)
+	}
+	if changed, err := tickers.ReloadIfChanged(ctx); err != nil || changed {
+		t.Fatalf("second check: ReloadIfChanged = %v, %v", changed, err)
+	}
+
+	// 社名だけが変わった再取り込みも反映する
+	if _, err := ImportCompaniesCSV(db, strings.NewReader("code,name\n6758,ソニーＧ\n")); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := tickers.ReloadIfChanged(ctx); err != nil || !changed {
+		t.Fatalf("after re-import: ReloadIfChanged = %v, %v", changed, err)
+	}
+	if got := tickerCodes(tickers.Extract("ソニーGの決算", "")); len(got) != 1 || got[0] != "6758" {
+		t.Fatalf("after re-import: Extract = %v, want [6758]", got)
+	}
+}
+
+// TestExtractNameBoundary は英字の社名が別の英単語の一部に一致しないことを確認します。
+func TestExtractNameBoundary(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := ImportCompaniesCSV(db, strings.NewReader("code,name\n6701,NEC\n6758,ソニーグループ\n")); err != nil {
+		t.Fatal(err)
+	}
+	tickers, err := NewTickerService(db, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Connect機能を強化", nil},
+		{"NECが新製品を発表", []string{"6701"}},
+		{"NEC、ソニーグループと提携", []string{"6701", "6758"}},
+		{"ソニーグループ株が上昇", []string{"6758"}},
+	}
+	for _, tt := range tests {
+		got := tickerCodes(tickers.Extract(tt.text, ""))
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("Extract(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"bot/models"
+	"bot/sources"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultMaxWatchItems = 50
+	// watchArticlesPerCode は1銘柄あたりダイジェストに載せる記事数の上限
+	watchArticlesPerCode = 5
+	// watchEmbedsPerMessage は1メッセージの埋め込み数。埋め込みの合計6000文字の上限に収まるよう控えめにする
+	watchEmbedsPerMessage = 5
+)
+
+var (
+	ErrWatchExists   = errors.New("既にウォッチリストに登録されています")
+	ErrWatchNotFound = errors.New("ウォッチリストに登録されていません")
+	ErrWatchLimit    = errors.New("ウォッチリストの上限に達しています")
+)
+
+// WatchlistService はユーザーごとのウォッチ銘柄と、その銘柄に関する記事のDMダイジェストを扱います。
+type WatchlistService struct {
+	db       *gorm.DB
+	notifier *Notifier
+	logger   *zap.Logger
+}
+
+func NewWatchlistService(db *gorm.DB, notifier *Notifier, logger *zap.Logger) *WatchlistService {
+	return &WatchlistService{db: db, notifier: notifier, logger: logger}
+}
+
+// Add は銘柄をウォッチリストに追加し、正規化した銘柄コードを返します。
+// 初めて登録するユーザーのダイジェストは日次になります。
+func (w *WatchlistService) Add(userID, code string) (string, error) {
+	code, err := NormalizeStockCode(code)
+	if err != nil {
+		return "", err
+	}
+
+	limit := viper.GetInt("watchlist.max_per_user")
+	if limit <= 0 {
+		limit = defaultMaxWatchItems
+	}
+
+	err = w.db.Transaction(func(tx *gorm.DB) error {
+		var count, exists int64
+		if err := tx.Model(&models.WatchItem{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.WatchItem{}).Where("user_id = ? AND stock_code = ?", userID, code).Count(&exists).Error; err != nil {
+			return err
+		}
+		if exists > 0 {
+			return ErrWatchExists
+		}
+		if count >= int64(limit) {
+			return fmt.Errorf("%w (%d銘柄)", ErrWatchLimit, limit)
+		}
+		if err := tx.Create(&models.WatchItem{UserID: userID, StockCode: code}).Error; err != nil {
+			return err
+		}
+		// 既存の送信設定は変更しない
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.WatchDigest{UserID: userID, Frequency: models.WatchDaily, LastSentAt: time.Now()}).Error
+	})
+	return code, err
+}
+
+// Remove は銘柄をウォッチリストから外します。
+func (w *WatchlistService) Remove(userID, code string) (string, error) {
+	code, err := NormalizeStockCode(code)
+	if err != nil {
+		return "", err
+	}
+	res := w.db.Where("user_id = ? AND stock_code = ?", userID, code).Delete(&models.WatchItem{})
+	if res.Error != nil {
+		return "", fmt.Errorf("ウォッチリストの更新に失敗しました: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return "", ErrWatchNotFound
+	}
+	return code, nil
+}
+
+// List はユーザーのウォッチ銘柄とダイジェストの送信頻度を返します。
+func (w *WatchlistService) List(userID string) ([]models.WatchItem, string, error) {
+	var items []models.WatchItem
+	if err := w.db.Where("user_id = ?", userID).Order("stock_code").Find(&items).Error; err != nil {
+		return nil, "", fmt.Errorf("ウォッチリストの取得に失敗しました: %w", err)
+	}
+	var digest models.WatchDigest
+	frequency := models.WatchDaily
+	if err := w.db.Where("user_id = ?", userID).Limit(1).Find(&digest).Error; err == nil && digest.Frequency != "" {
+		frequency = digest.Frequency
+	}
+	return items, frequency, nil
+}
+
+// Watched は銘柄コードのいずれかを誰かがウォッチしているかどうかを返します。
+// 通知のルーティング (stock_code in watchlist) から記事ごとに呼ばれます。
+func (w *WatchlistService) Watched(codes []string) bool {
+	if len(codes) == 0 {
+		return false
+	}
+	var count int64
+	if err := w.db.Model(&models.WatchItem{}).Where("stock_code IN ?", codes).Limit(1).Count(&count).Error; err != nil {
+		w.logger.Warn("ウォッチリストの照会に失敗しました", zap.Error(err))
+		return false
+	}
+	return count > 0
+}
+
+// SetFrequency はダイジェストの送信頻度を変更します。
+// off から再開した場合は、停止していた間の記事をまとめて送らないよう、今から後の記事を対象にします。
+func (w *WatchlistService) SetFrequency(userID, frequency string) error {
+	switch frequency {
+	case models.WatchDaily, models.WatchHourly, models.WatchOff:
+	default:
+		return fmt.Errorf("不明な送信頻度です: %s", frequency)
+	}
+	updates := clause.AssignmentColumns([]string{"frequency", "updated_at"})
+	updates = append(updates, clause.Assignment{
+		Column: clause.Column{Name: "last_sent_at"},
+		Value: gorm.Expr("CASE WHEN watch_digests.frequency = ? AND excluded.frequency <> ? THEN excluded.last_sent_at ELSE watch_digests.last_sent_at END",
+			models.WatchOff, models.WatchOff),
+	})
+	return w.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: updates,
+	}).Create(&models.WatchDigest{UserID: userID, Frequency: frequency, LastSentAt: time.Now()}).Error
+}
+
+// SendDigests は指定した頻度のユーザー全員に、前回送信以降の記事をDMで送ります。
+// 記事がないユーザーには送信しません。DM は送信待ちキューに追加し、Notifier が送ります。
+func (w *WatchlistService) SendDigests(ctx context.Context, frequency string) error {
+	var digests []models.WatchDigest
+	if err := w.db.WithContext(ctx).Where("frequency = ?", frequency).Find(&digests).Error; err != nil {
+		return fmt.Errorf("ダイジェスト対象ユーザーの取得に失敗しました: %w", err)
+	}
+
+	now := time.Now()
+	var failed int
+	for _, d := range digests {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := w.sendDigest(ctx, d, now); err != nil {
+			failed++
+			w.logger.Warn("ウォッチリストのダイジェスト送信に失敗しました", zap.String("user_id", d.UserID), zap.Error(err))
+			continue
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d/%d人へのダイジェスト送信に失敗しました", failed, len(digests))
+	}
+	return nil
+}
+
+func (w *WatchlistService) sendDigest(ctx context.Context, d models.WatchDigest, now time.Time) error {
+	var codes []string
+	if err := w.db.WithContext(ctx).Model(&models.WatchItem{}).
+		Where("user_id = ?", d.UserID).
+		Order("stock_code").
+		Pluck("stock_code", &codes).Error; err != nil {
+		return err
+	}
+
+	var articles []watchedArticle
+	if len(codes) > 0 {
+		if err := w.db.WithContext(ctx).Model(&models.Article{}).
+			Select("articles.*, article_tickers.stock_code AS matched_code").
+			Joins("JOIN article_tickers ON article_tickers.article_id = articles.id").
+			Where("article_tickers.stock_code IN ? AND articles.created_at > ? AND articles.created_at <= ?", codes, d.LastSentAt, now).
+			Order("articles.published_at DESC").
+			Scan(&articles).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(articles) > 0 {
+		for _, payload := range watchDigestPayloads(codes, articles, d.LastSentAt, now) {
+			if err := w.notifier.EnqueueDM(ctx, d.UserID, payload); err != nil {
+				return err
+			}
+		}
+		w.logger.Info("ウォッチリストのダイジェストを送信しました",
+			zap.String("user_id", d.UserID),
+			zap.Int("articles", len(articles)))
+	}
+
+	return w.db.WithContext(ctx).Model(&models.WatchDigest{}).
+		Where("user_id = ?", d.UserID).
+		Update("last_sent_at", now).Error
+}
+
+// watchedArticle は記事と、ウォッチリストのどの銘柄に一致したかです。
+// 1記事が複数の銘柄に関連する場合は銘柄ごとに1行になります。
+type watchedArticle struct {
+	models.Article `gorm:"embedded"`
+	MatchedCode    string
+}
+
+// watchDigestPayloads はダイジェストの DM を組み立てます。見出しは最初のメッセージに付けます。
+func watchDigestPayloads(codes []string, articles []watchedArticle, from, to time.Time) []OutboundPayload {
+	byCode := make(map[string][]watchedArticle)
+	for _, a := range articles {
+		byCode[a.MatchedCode] = append(byCode[a.MatchedCode], a)
+	}
+
+	jst := time.FixedZone("JST", 9*3600)
+	var embeds []*discordgo.MessageEmbed
+	for _, code := range codes {
+		list := byCode[code]
+		if len(list) == 0 {
+			continue
+		}
+		var desc string
+		for idx, a := range list {
+			if idx == watchArticlesPerCode {
+				desc += fmt.Sprintf("ほか%d件", len(list)-watchArticlesPerCode)
+				break
+			}
+			desc += fmt.Sprintf("`%s` [%s](%s)\n", a.PublishedAt.In(jst).Format("01/02 15:04"), truncateRunes(a.Title, 60), a.URL)
+		}
+		embeds = append(embeds, &discordgo.MessageEmbed{
+			Title:       fmt.Sprintf("📈 %s (%d件)", code, len(list)),
+			URL:         fmt.Sprintf("https://kabutan.jp/stock/?code=%s", code),
+			Description: desc,
+			Color:       0x00BFFF,
+			Image:       &discordgo.MessageEmbedImage{URL: sources.ChartURL(code, to)},
+		})
+	}
+
+	header := fmt.Sprintf("👀 **ウォッチリスト ダイジェスト** (%s ～ %s)",
+		from.In(jst).Format("01/02 15:04"), to.In(jst).Format("01/02 15:04"))
+	var payloads []OutboundPayload
+	for start := 0; start < len(embeds); start += watchEmbedsPerMessage {
+		end := start + watchEmbedsPerMessage
+		if end > len(embeds) {
+			end = len(embeds)
+		}
+		payload := OutboundPayload{Embeds: embeds[start:end]}
+		if start == 0 {
+			payload.Content = header
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+func truncateRunes(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max]) + "…"
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"bot/models"
+
+	"go.uber.org/zap"
+)
+
+// TestSetFrequencyResumesFromNow は off から再開したときに停止中の記事を送らないことを確認します。
+func TestSetFrequencyResumesFromNow(t *testing.T) {
+	db := openTestDB(t)
+	w := NewWatchlistService(db, nil, zap.NewNop())
+	old := time.Now().Add(-72 * time.Hour)
+	if err := db.Create(&models.WatchDigest{UserID: "u1", Frequency: models.WatchOff, LastSentAt: old}).Error; err != nil {
+		t.Fatal(err)
+	}
+	lastSent := func() time.Time {
+		t.Helper()
+		var d models.WatchDigest
+		if err := db.First(&d, "user_id = ?", "u1").Error; err != nil {
+			t.Fatal(err)
+		}
+		return d.LastSentAt
+	}
+
+	if err := w.SetFrequency("u1", models.WatchDaily); err != nil {
+		t.Fatalf("SetFrequency(daily): %v", err)
+	}
+	resumed := lastSent()
+	if time.Since(resumed) > time.Minute {
+		t.Fatalf("last_sent_at = %v, want reset on resume", resumed)
+	}
+
+	// 送信中の頻度の変更では last_sent_at を変えない
+	if err := db.Model(&models.WatchDigest{}).Where("user_id = ?", "u1").Update("last_sent_at", old).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetFrequency("u1", models.WatchHourly); err != nil {
+		t.Fatalf("SetFrequency(hourly): %v", err)
+	}
+	if got := lastSent(); !got.Equal(old) {
+		t.Errorf("last_sent_at = %v, want %v kept", got, old)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bot/command"
+	"bot/database"
+	"bot/services"
+	"context"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	// shutdownGrace は期限切れで中断したジョブの終了を待つ時間
+	shutdownGrace = 5 * time.Second
+)
+
+// heartbeat は ctx が終了するまで5分ごとに稼働ログを出力します。
+func heartbeat(ctx context.Context, logger *zap.Logger) {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			logger.Info("システムは動作中です",
+				zap.Time("最終チェック", time.Now()),
+			)
+		}
+	}
+}
+
+// shutdown はスケジューラを止め、実行中のジョブとコマンドの応答を shutdown.timeout まで待ってから、
+// 送信待ちの通知を同じ期限まで送り、DB と Discord の接続を閉じてログを書き出します。
+// 期限を過ぎた場合は cancelRun で実行中の処理を中断します。送りきれなかった通知は次回の起動時に送られます。
+func shutdown(
+	logger *zap.Logger,
+	scheduler *services.Scheduler,
+	discord *discordgo.Session,
+	bodies *services.BodyFetcher,
+	notifier *services.Notifier,
+	stopBodies, cancelRun, stopNotifier context.CancelFunc,
+) {
+	timeout := viper.GetDuration("shutdown.timeout")
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	logger.Info("シャットダウンを開始します", zap.Duration("timeout", timeout))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stopBodies()
+	schedErr := scheduler.Shutdown(ctx)
+	if schedErr != nil {
+		logger.Warn("期限までに終わらなかったジョブを中断します", zap.Error(schedErr))
+	}
+	if err := commands.Wait(ctx); err != nil {
+		logger.Warn("期限までに終わらなかったコマンドを中断します", zap.Error(err))
+	}
+	cancelRun()
+	if schedErr != nil {
+		// 中断されたジョブが戻るまで少しだけ待つ
+		graceCtx, graceCancel := context.WithTimeout(context.Background(), shutdownGrace)
+		if err := scheduler.Shutdown(graceCtx); err != nil {
+			logger.Warn("中断したジョブが終了しないまま切断します", zap.Error(err))
+		}
+		graceCancel()
+	}
+	bodies.Wait()
+
+	if err := notifier.Drain(ctx); err != nil {
+		logger.Warn("送信待ちの通知を残して終了します。次回の起動時に送信されます", zap.Error(err))
+	}
+	stopNotifier()
+	notifier.Wait()
+
+	// 中断したジョブが書き込みを終えるまで待ってから閉じる
+	errMutex.Lock()
+	if err := database.Close(db); err != nil {
+		logger.Error("データベースの切断に失敗しました", zap.Error(err))
+	}
+	errMutex.Unlock()
+
+	if err := discord.Close(); err != nil {
+		logger.Error("Discordの切断に失敗しました", zap.Error(err))
+	}
+	logger.Info("シャットダウンが完了しました")
+	_ = logger.Sync()
+}
@@ -0,0 +1,71 @@
+package sources
+
+import (
+	"bot/market"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// NewDefaultRegistry は組み込みのソースを登録したレジストリを返します。
+// 新しいサイトを追加する場合はここに登録するだけで、定期実行・/scrape toggle・/scrape now に反映されます。
+// 株探は scraping.kabutan_urls の各URLを、パスで選んだプロファイルのソースとして登録します。
+// 実行間隔は scraping.schedules.<name>、実行する時間帯は scraping.windows.<name> で上書きできます。
+func NewDefaultRegistry(logger *zap.Logger) *Registry {
+	r := NewRegistry()
+
+	for _, rawURL := range viper.GetStringSlice("scraping.kabutan_urls") {
+		src, err := NewKabutan(logger, rawURL)
+		if err != nil {
+			logger.Warn("株探のURLをスキップしました", zap.String("url", rawURL), zap.Error(err))
+			continue
+		}
+		if _, ok := r.Get(src.Name()); ok {
+			logger.Warn("同じプロファイルのURLが重複しているためスキップしました", zap.String("url", rawURL), zap.String("site", src.Name()))
+			continue
+		}
+		p := src.profile
+		fallback := p.schedule
+		if fallback == "" {
+			fallback = viper.GetString("scraping.interval")
+		}
+		r.Register(Entry{
+			Source:        src,
+			Schedule:      schedule(p.name, fallback),
+			DefaultFilter: viper.GetString(p.filterKey),
+			MaxNew:        viper.GetInt("scraping.max_articles." + p.name),
+			MaxPages:      viper.GetInt("scraping.max_pages"),
+			UrgentOnly:    p.urgentOnly,
+			Window:        window(logger, p.name, p.window),
+		})
+	}
+	r.Register(Entry{
+		Source:        NewTraders(logger),
+		Schedule:      schedule("traders", "*/2 * * * *"),
+		DefaultFilter: viper.GetString("traders.filter"),
+		MaxNew:        viper.GetInt("scraping.max_articles.traders"),
+		Window:        window(logger, "traders", market.WindowAlways),
+	})
+
+	return r
+}
+
+func schedule(name, fallback string) string {
+	if s := viper.GetString("scraping.schedules." + name); s != "" {
+		return s
+	}
+	return fallback
+}
+
+func window(logger *zap.Logger, name string, fallback market.Window) market.Window {
+	v := viper.GetString("scraping.windows." + name)
+	if v == "" {
+		return fallback
+	}
+	w, err := market.ParseWindow(v)
+	if err != nil {
+		logger.Warn("実行時間帯の設定が不正なため既定値を使います", zap.String("site", name), zap.Error(err))
+		return fallback
+	}
+	return w
+}
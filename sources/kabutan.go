@@ -0,0 +1,382 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bot/market"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	kabutanChartURL = "https://funit.api.kabutan.jp/jp/chart?c=%s&a=1&s=1&m=1&v=%d"
+)
+
+// ChartURL は銘柄のチャート画像のURLを返します。v はDiscord側のキャッシュを避けるための値です。
+func ChartURL(code string, t time.Time) string {
+	return fmt.Sprintf(kabutanChartURL, code, t.Unix())
+}
+
+// kabutanProfile は株探の一覧ページ1種類分の解析方法と、レジストリに登録する際の既定値です。
+// scraping.kabutan_urls の各URLはパスに最も長く一致するプロファイルで解析します。
+type kabutanProfile struct {
+	// name はソース名。/scrape toggle や /set filter、保存する記事の Site に使う
+	name string
+	// path はこのプロファイルを使うURLのパスの接頭辞
+	path string
+
+	// rows は1記事分の行のセレクタ
+	rows string
+	// title と link はタイトルとリンクのセレクタ（行からの相対）。link が空の場合は title の href を使う
+	title string
+	link  string
+	// code と codeAttr は銘柄コードのセレクタと属性。codeAttr が空の場合はテキストを使う
+	code     string
+	codeAttr string
+	// category は行内のカテゴリのセレクタ。空の場合はページの heading、それも取れない場合は label を使う
+	category string
+	heading  string
+	label    string
+	// urgent が true の場合、カテゴリに kk_b クラスが付いた行を Urgent にする
+	urgent bool
+	// undated が true の一覧は行に日時がないため、取得日を日付とし、URLに日付を付けて日ごとに別の記事として扱う
+	undated bool
+
+	// 以下はレジストリに登録する際の既定値。schedule が空の場合は scraping.interval を使う
+	schedule   string
+	filterKey  string
+	urgentOnly bool
+	window     market.Window
+}
+
+// kabutanProfiles は既知の一覧ページの解析方法です。新しい一覧はここに追加し、scraping.kabutan_urls にURLを書きます。
+var kabutanProfiles = []kabutanProfile{
+	{
+		// マーケットニュース。銘柄列がないためタイトルは3列目
+		name:      "kabutan",
+		path:      "/news/marketnews/",
+		rows:      ".s_news_list.mgbt0 tr",
+		title:     "td:nth-child(3) a",
+		category:  "td:nth-child(2) div.newslist_ctg",
+		filterKey: "kabutan.filter",
+	},
+	{
+		// 適時開示を含むニュース一覧。リアルタイムIR通知のため緊急記事のみ通知する
+		name:       "ir",
+		path:       "/news/",
+		rows:       "#news_contents .s_news_list tr",
+		title:      "td:nth-child(4) a",
+		code:       "td:nth-child(3)",
+		codeAttr:   "data-code",
+		category:   "td:nth-child(2) div.newslist_ctg",
+		urgent:     true,
+		schedule:   "*/1 * * * *",
+		filterKey:  "kabutan.ir_filter",
+		urgentOnly: true,
+		window:     market.WindowMarket,
+	},
+	{
+		// ストップ高・急騰などの銘柄一覧。行は銘柄単位で、カテゴリはページの見出しから取る
+		name:      "warning",
+		path:      "/warning/",
+		rows:      "table.stock_table tbody tr",
+		title:     "th",
+		link:      "td:nth-child(1) a",
+		code:      "td:nth-child(1) a",
+		heading:   "#main h1, #main h2",
+		label:     "株価注意",
+		undated:   true,
+		schedule:  "*/10 * * * *",
+		filterKey: "kabutan.warning_filter",
+	},
+	{
+		// 年初来高値などの銘柄探索の一覧。warning と同じ形式の表
+		name:      "tansaku",
+		path:      "/tansaku/",
+		rows:      "table.stock_table tbody tr",
+		title:     "th",
+		link:      "td:nth-child(1) a",
+		code:      "td:nth-child(1) a",
+		heading:   "#main h1, #main h2",
+		label:     "銘柄探索",
+		undated:   true,
+		schedule:  "*/30 * * * *",
+		filterKey: "kabutan.tansaku_filter",
+	},
+}
+
+// profileFor はURLのパスに最も長く一致するプロファイルを返します。
+func profileFor(rawURL string) (kabutanProfile, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return kabutanProfile{}, fmt.Errorf("URLの解析に失敗しました: %w", err)
+	}
+	path := u.Path
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	var (
+		best  kabutanProfile
+		found bool
+	)
+	for _, p := range kabutanProfiles {
+		if strings.HasPrefix(path, p.path) && len(p.path) > len(best.path) {
+			best, found = p, true
+		}
+	}
+	if !found {
+		return kabutanProfile{}, fmt.Errorf("対応するプロファイルがありません: %s", rawURL)
+	}
+	return best, nil
+}
+
+// Kabutan は株探の一覧ページ1つを、URLのパスで選んだプロファイルに従って取得します。
+// ニュース一覧のうち緊急度の高い記事（カテゴリに kk_b クラスが付いたもの）は Urgent になります。
+type Kabutan struct {
+	logger  *zap.Logger
+	url     string
+	profile kabutanProfile
+	loc     *time.Location
+}
+
+// NewKabutan は scraping.kabutan_urls の1件分のソースを作ります。
+// パスに対応するプロファイルがない場合はエラーを返します。
+func NewKabutan(logger *zap.Logger, rawURL string) (*Kabutan, error) {
+	profile, err := profileFor(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		loc = time.FixedZone("JST", 9*3600)
+	}
+	return &Kabutan{logger: logger, url: rawURL, profile: profile, loc: loc}, nil
+}
+
+func (k *Kabutan) Name() string {
+	return k.profile.name
+}
+
+func (k *Kabutan) Fetch(ctx context.Context, opts FetchOptions) ([]Item, error) {
+	c := colly.NewCollector(
+		colly.UserAgent("Mozilla/5.0"),
+		colly.AllowedDomains("kabutan.jp"),
+		colly.Async(true),
+		colly.StdlibContext(ctx),
+	)
+
+	c.Limit(&colly.LimitRule{
+		DomainGlob:  "*",
+		Parallelism: viper.GetInt("scraping.parallelism"),
+		RandomDelay: time.Duration(viper.GetInt("scraping.delay_seconds")) * time.Second,
+	})
+
+	var page pageBuffer
+
+	c.OnRequest(func(r *colly.Request) {
+		k.logger.Debug("訪問開始", zap.String("site", k.Name()), zap.String("url", r.URL.String()))
+	})
+
+	c.OnHTML("html", func(e *colly.HTMLElement) {
+		category := ""
+		if k.profile.heading != "" {
+			category = strings.TrimSpace(e.DOM.Find(k.profile.heading).First().Text())
+		}
+		if category == "" {
+			category = k.profile.label
+		}
+
+		e.ForEach(k.profile.rows, func(_ int, row *colly.HTMLElement) {
+			item, err := k.parseRow(row, category)
+			if err != nil {
+				// 見出し行などもここに来るため Debug に留める
+				k.logger.Debug("必須項目不足、スキップ", zap.String("site", k.Name()), zap.Error(err))
+				return
+			}
+			page.add(item)
+		})
+	})
+
+	// 毎分の実行では1ページ目に既知の記事が並ぶため、通常は2ページ目で打ち切られる
+	return paginate(ctx, c, withFilter(k.url, opts.Filter), opts, &page, k.logger.With(zap.String("site", k.Name())))
+}
+
+// parseRow は一覧の1行を Item に変換します。
+// category は行内にカテゴリがない一覧で使うページ単位のカテゴリです。
+func (k *Kabutan) parseRow(e *colly.HTMLElement, category string) (Item, error) {
+	p := k.profile
+	item := Item{
+		Site:     p.name,
+		Category: category,
+		Title:    strings.TrimSpace(e.ChildText(p.title)),
+	}
+	if p.category != "" {
+		item.Category = e.ChildText(p.category)
+	}
+	if p.code != "" {
+		if p.codeAttr != "" {
+			item.StockCode = e.ChildAttr(p.code, p.codeAttr)
+		} else {
+			item.StockCode = strings.TrimSpace(e.ChildText(p.code))
+		}
+	}
+	if p.urgent {
+		item.Urgent = strings.Contains(e.ChildAttr(p.category, "class"), "kk_b")
+	}
+
+	linkSel := p.link
+	if linkSel == "" {
+		linkSel = p.title
+	}
+	href := e.ChildAttr(linkSel, "href")
+	if item.Title == "" || href == "" {
+		return Item{}, fmt.Errorf("必須項目不足 (title=%q, href=%q)", item.Title, href)
+	}
+
+	link := e.Request.AbsoluteURL(href)
+	if p.undated {
+		// 同じ銘柄が翌日以降に再び載っても別の記事として保存されるよう、URLに取得日を付ける
+		now := time.Now().In(k.loc)
+		item.PublishedAt = now
+		item.Title = fmt.Sprintf("[%s] %s", item.Category, item.Title)
+		link = withDate(link, now)
+	} else {
+		datetime := e.ChildAttr("td.news_time time", "datetime")
+		if datetime == "" {
+			return Item{}, fmt.Errorf("必須項目不足 (title=%q, datetime=%q)", item.Title, datetime)
+		}
+		pub, err := time.Parse(time.RFC3339, datetime)
+		if err != nil {
+			return Item{}, fmt.Errorf("日時パースエラー: %w", err)
+		}
+		item.PublishedAt = pub
+	}
+
+	norm, err := normalizeURL(link)
+	if err != nil {
+		return Item{}, fmt.Errorf("URL正規化エラー: %w", err)
+	}
+	item.URL = norm
+	return item, nil
+}
+
+// withDate はURLのクエリに date=YYYYMMDD を付与します。
+func withDate(rawURL string, t time.Time) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	q.Set("date", t.Format("20060102"))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// pageBuffer は1ページ分の記事を貯めます。非同期コレクターのコールバックからも使えます。
+type pageBuffer struct {
+	mu    sync.Mutex
+	items []Item
+}
+
+func (b *pageBuffer) add(item Item) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.items = append(b.items, item)
+}
+
+// take は貯まった記事を返して空にします。
+func (b *pageBuffer) take() []Item {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	items := b.items
+	b.items = nil
+	return items
+}
+
+// paginate は株探の一覧を first から .pagination のリンクで1ページずつたどります。
+// opts.MaxPages に達したとき、記事のないページやすべて保存済みのページに達したとき、
+// 次のページへのリンクがないときに終了します。
+// 1ページ目の取得に失敗した場合は記事なしでエラーを返し、2ページ目以降の失敗では
+// それまでに取得した記事と *PartialError を返します。
+func paginate(ctx context.Context, c *colly.Collector, first string, opts FetchOptions, page *pageBuffer, logger *zap.Logger) ([]Item, error) {
+	var (
+		mu      sync.Mutex
+		links   = make(map[int]string)
+		pageErr error
+	)
+	// 非同期コレクターのため、リクエストエラーはコールバックで拾う。ページは1つずつ取得するため、直前に訪問したページのエラーになる
+	c.OnError(func(r *colly.Response, err error) {
+		mu.Lock()
+		pageErr = fmt.Errorf("一覧ページ取得エラー (status %d): %w", r.StatusCode, err)
+		mu.Unlock()
+	})
+	c.OnHTML(".pagination a[href]", func(e *colly.HTMLElement) {
+		abs := e.Request.AbsoluteURL(e.Attr("href"))
+		u, err := url.Parse(abs)
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(u.Query().Get("page"))
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		links[n] = abs
+		mu.Unlock()
+	})
+
+	var items []Item
+	target := first
+	for n := 1; ; n++ {
+		if err := c.Visit(target); err != nil {
+			err = fmt.Errorf("サイト訪問エラー: %w", err)
+			if n == 1 {
+				return nil, err
+			}
+			logger.Warn("一覧ページの取得に失敗したため打ち切ります", zap.String("url", target), zap.Error(err))
+			return items, &PartialError{Page: n, Err: err}
+		}
+		c.Wait()
+
+		mu.Lock()
+		err := pageErr
+		pageErr = nil
+		mu.Unlock()
+		if err != nil {
+			if n == 1 {
+				return nil, err
+			}
+			logger.Warn("一覧ページの取得に失敗したため、取得済みの記事で打ち切ります",
+				zap.String("url", target), zap.Int("page", n), zap.Int("items", len(items)), zap.Error(err))
+			return items, &PartialError{Page: n, Err: err}
+		}
+
+		got := page.take()
+		items = append(items, got...)
+		if n >= opts.pages() || len(got) == 0 || ctx.Err() != nil {
+			break
+		}
+		if opts.allKnown(got) {
+			logger.Debug("保存済みの記事のみのページに達したため打ち切ります", zap.String("url", target), zap.Int("page", n))
+			break
+		}
+
+		mu.Lock()
+		next, ok := links[n+1]
+		mu.Unlock()
+		if !ok {
+			break
+		}
+		target = next
+	}
+	return items, nil
+}
@@ -0,0 +1,135 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gocolly/colly/v2"
+	"go.uber.org/zap"
+)
+
+// listServer は pages 件の一覧ページを返すテスト用サーバーです。failPage のページは 500 を返します。
+func listServer(t *testing.T, pages, failPage int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if n == 0 {
+			n = 1
+		}
+		if n == failPage {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "<html><body><table>")
+		for i := 1; i <= 2; i++ {
+			fmt.Fprintf(w, `<tr><td class="title">p%d-%d</td></tr>`, n, i)
+		}
+		fmt.Fprint(w, `</table><div class="pagination">`)
+		for p := 1; p <= pages; p++ {
+			fmt.Fprintf(w, `<a href="/list?page=%d">%d</a>`, p, p)
+		}
+		fmt.Fprint(w, "</div></body></html>")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func runPaginate(t *testing.T, srv *httptest.Server, opts FetchOptions) ([]Item, error) {
+	t.Helper()
+	c := colly.NewCollector(colly.Async(true))
+	var page pageBuffer
+	c.OnHTML("tr", func(e *colly.HTMLElement) {
+		page.add(Item{Title: e.ChildText(".title")})
+	})
+	return paginate(context.Background(), c, srv.URL+"/list", opts, &page, zap.NewNop())
+}
+
+func titles(items []Item) []string {
+	var out []string
+	for _, item := range items {
+		out = append(out, item.Title)
+	}
+	return out
+}
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name     string
+		pages    int
+		failPage int
+		opts     FetchOptions
+		want     []string
+		wantErr  bool
+		// wantPage は *PartialError で返る失敗ページ。0 の場合は PartialError でないこと
+		wantPage int
+	}{
+		{
+			name:  "stops at max pages",
+			pages: 5,
+			opts:  FetchOptions{MaxPages: 2},
+			want:  []string{"p1-1", "p1-2", "p2-1", "p2-2"},
+		},
+		{
+			name:  "stops without a next link",
+			pages: 2,
+			opts:  FetchOptions{MaxPages: 5},
+			want:  []string{"p1-1", "p1-2", "p2-1", "p2-2"},
+		},
+		{
+			name:  "stops at a page of stored items",
+			pages: 5,
+			opts:  FetchOptions{MaxPages: 5, Known: func(item Item) bool { return item.Title[:2] == "p2" }},
+			want:  []string{"p1-1", "p1-2", "p2-1", "p2-2"},
+		},
+		{
+			name:     "later page failure keeps earlier items",
+			pages:    3,
+			failPage: 2,
+			opts:     FetchOptions{MaxPages: 3},
+			want:     []string{"p1-1", "p1-2"},
+			wantErr:  true,
+			wantPage: 2,
+		},
+		{
+			name:     "last page failure keeps earlier items",
+			pages:    3,
+			failPage: 3,
+			opts:     FetchOptions{MaxPages: 3},
+			want:     []string{"p1-1", "p1-2", "p2-1", "p2-2"},
+			wantErr:  true,
+			wantPage: 3,
+		},
+		{
+			name:     "first page failure is an error",
+			pages:    3,
+			failPage: 1,
+			opts:     FetchOptions{MaxPages: 3},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := runPaginate(t, listServer(t, tt.pages, tt.failPage), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			var partial *PartialError
+			if errors.As(err, &partial) {
+				if partial.Page != tt.wantPage {
+					t.Errorf("PartialError.Page = %d, want %d", partial.Page, tt.wantPage)
+				}
+			} else if tt.wantPage != 0 {
+				t.Errorf("err = %v, want a PartialError", err)
+			}
+			got := titles(items)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("items = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
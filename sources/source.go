@@ -0,0 +1,172 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"bot/market"
+)
+
+// Item はスクレイパーが返す1記事分のデータです。
+type Item struct {
+	Site        string
+	Category    string
+	StockCode   string
+	Title       string
+	URL         string // 正規化済みのURL
+	PublishedAt time.Time
+	Urgent      bool
+	// Body は記事ページから取得した本文。一覧ページからは取れないため、保存後に埋まる場合のみ設定される
+	Body string
+}
+
+// FetchOptions は一覧ページの取得条件です。
+type FetchOptions struct {
+	// Filter は一覧ページのURLに付与するクエリ文字列
+	Filter string
+	// MaxPages はたどる一覧ページ数の上限。0以下は1ページ目のみ
+	MaxPages int
+	// Known は記事が保存済みかどうかを返します。nil の場合はすべて未保存として扱う
+	Known func(Item) bool
+}
+
+// pages は MaxPages を1以上に丸めた値を返します。
+func (o FetchOptions) pages() int {
+	if o.MaxPages < 1 {
+		return 1
+	}
+	return o.MaxPages
+}
+
+// allKnown はページ上の記事がすべて保存済みかどうかを返します。
+func (o FetchOptions) allKnown(items []Item) bool {
+	if o.Known == nil || len(items) == 0 {
+		return false
+	}
+	for _, item := range items {
+		if !o.Known(item) {
+			return false
+		}
+	}
+	return true
+}
+
+// PartialError は2ページ目以降の取得に失敗し、それまでに取得した記事のみを返したことを表します。
+type PartialError struct {
+	// Page は取得に失敗したページ番号
+	Page int
+	Err  error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%dページ目以降を取得できませんでした: %v", e.Page, e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// Source は1つのニュース一覧からの記事取得を表します。
+// Fetch は一覧上の記事をすべて返し、重複除外や保存は呼び出し側で行います。
+// ページ送りに対応するソースは opts.MaxPages までたどり、
+// すべて保存済みのページに達した時点で打ち切ります。
+// 途中のページで失敗した場合は、取得済みの記事とともに *PartialError を返します。
+type Source interface {
+	Name() string
+	Fetch(ctx context.Context, opts FetchOptions) ([]Item, error)
+}
+
+// Entry はレジストリに登録されたソースと、その実行設定です。
+type Entry struct {
+	Source   Source
+	Schedule string
+	// DefaultFilter は /set filter で上書きされていない場合に使うクエリ文字列
+	DefaultFilter string
+	// MaxNew は1回の実行で保存・通知する新着記事の上限（0で無制限）
+	MaxNew int
+	// MaxPages は1回の実行でたどる一覧ページ数の上限。停止中に取りこぼした記事の補完に使う
+	MaxPages int
+	// UrgentOnly が true の場合、Urgent でない記事を既定のルールで通知しない。
+	// ルーティングの条件 urgent_only に渡し、どのルールで絞り込むかはルール側で決める
+	UrgentOnly bool
+	// Window は定期実行する時間帯。/scrape now による手動実行には適用しない
+	Window market.Window
+}
+
+func (e Entry) Name() string {
+	return e.Source.Name()
+}
+
+// Registry は登録順を保ったソースの一覧です。
+// スケジュール登録、/scrape toggle、/scrape now はこの一覧から組み立てます。
+type Registry struct {
+	mu      sync.RWMutex
+	order   []string
+	entries map[string]Entry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]Entry)}
+}
+
+// Register はソースを登録します。同名のソースは上書きします。
+func (r *Registry) Register(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := e.Name()
+	if _, ok := r.entries[name]; !ok {
+		r.order = append(r.order, name)
+	}
+	r.entries[name] = e
+}
+
+// Get は名前でソースを取得します。
+func (r *Registry) Get(name string) (Entry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.entries[name]
+	return e, ok
+}
+
+// Entries は登録順のソース一覧を返します。
+func (r *Registry) Entries() []Entry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entries := make([]Entry, 0, len(r.order))
+	for _, name := range r.order {
+		entries = append(entries, r.entries[name])
+	}
+	return entries
+}
+
+// withFilter は一覧ページのURLにフィルタのクエリ文字列を付与します。
+func withFilter(base, filter string) string {
+	if filter == "" {
+		return base
+	}
+	return base + "?" + filter
+}
+
+// normalizeURL はパス中にエスケープされた "?" を戻し、
+// scheme://host/path[?query] の形に揃えます。既存の記事との重複判定に使うため形式を変えないでください。
+func normalizeURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	decodedPath, err := url.PathUnescape(u.EscapedPath())
+	if err != nil {
+		return "", err
+	}
+	decodedPath = strings.ReplaceAll(decodedPath, "%3F", "?")
+	u.Path = decodedPath
+
+	if u.RawQuery != "" {
+		return fmt.Sprintf("%s://%s%s?%s", u.Scheme, u.Host, u.Path, u.RawQuery), nil
+	}
+	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, u.Path), nil
+}
@@ -0,0 +1,93 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	tradersBaseURL = "https://www.traders.co.jp"
+	tradersNewsURL = tradersBaseURL + "/news/list/ALL/1"
+)
+
+var weekdayRE = regexp.MustCompile(`\(.+?\)`)
+
+// Traders はトレーダーズ・ウェブのニュース一覧を取得します。
+type Traders struct {
+	logger *zap.Logger
+	loc    *time.Location
+}
+
+func NewTraders(logger *zap.Logger) *Traders {
+	// JST ロケーションを読み込む
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		logger.Error("ロケーションロード失敗", zap.Error(err))
+		loc = time.FixedZone("JST", 9*3600) // フォールバック
+	}
+	return &Traders{logger: logger, loc: loc}
+}
+
+func (t *Traders) Name() string {
+	return "traders"
+}
+
+func (t *Traders) Fetch(ctx context.Context, opts FetchOptions) ([]Item, error) {
+	var items []Item
+	c := colly.NewCollector(
+		colly.UserAgent("Mozilla/5.0"),
+		colly.StdlibContext(ctx),
+	)
+
+	c.OnRequest(func(r *colly.Request) {
+		t.logger.Debug("訪問開始", zap.String("url", r.URL.String()))
+	})
+
+	c.OnHTML(".news_container", func(e *colly.HTMLElement) {
+		// 日時パース: "2025/04/29(火) 18:13"等 → "2025/04/29 18:13"
+		ts := strings.TrimSpace(weekdayRE.ReplaceAllString(e.ChildText(".timestamp"), ""))
+		parsedTime, err := time.ParseInLocation("2006/01/02 15:04", ts, t.loc)
+		if err != nil {
+			t.logger.Warn("日時パースエラー", zap.String("raw", ts), zap.Error(err))
+			return
+		}
+
+		// タイトル + URL
+		title := e.ChildText(".news_headline a.news_link")
+		href := e.ChildAttr(".news_headline a.news_link", "href")
+		if title == "" || href == "" {
+			t.logger.Debug("必須項目不足、スキップ", zap.String("title", title))
+			return
+		}
+
+		items = append(items, Item{
+			Site:        t.Name(),
+			Category:    "トレーダーズ",
+			Title:       title,
+			URL:         resolveURL(tradersBaseURL, href),
+			PublishedAt: parsedTime,
+		})
+	})
+
+	c.OnError(func(r *colly.Response, err error) {
+		t.logger.Error("Traders news crawl error", zap.Int("status", r.StatusCode), zap.Error(err))
+	})
+
+	if err := c.Visit(withFilter(tradersNewsURL, opts.Filter)); err != nil {
+		return nil, fmt.Errorf("サイト訪問エラー: %w", err)
+	}
+	return items, nil
+}
+
+func resolveURL(baseStr, path string) string {
+	u, _ := url.Parse(baseStr)
+	r, _ := url.Parse(path)
+	return u.ResolveReference(r).String()
+}
@@ -17,12 +17,54 @@ type SystemStats struct {
 	CPUPercent    float64 // 直近キャッシュ
 }
 
+// AI API の状態
+const (
+	AIStateOK       = "ok"
+	AIStateDegraded = "degraded" // 直近の呼び出しが失敗している
+	AIStateOpen     = "open"     // サーキットブレーカー作動中
+)
+
+// AIStatus は AI API 呼び出しの直近の状態です。
+type AIStatus struct {
+	Provider            string
+	State               string
+	ConsecutiveFailures int
+	LastError           string
+	LastErrorAt         time.Time
+	LastSuccessAt       time.Time
+	OpenUntil           time.Time
+}
+
 var (
 	stats      SystemStats
+	aiStatus   AIStatus
 	statsMutex sync.RWMutex
 	logger     *zap.Logger
 )
 
+// SetAIStatus は AI API の状態を更新します。
+func SetAIStatus(st AIStatus) {
+	statsMutex.Lock()
+	prev := aiStatus.State
+	aiStatus = st
+	statsMutex.Unlock()
+
+	if logger != nil && prev != st.State {
+		logger.Info("AI API の状態が変化しました",
+			zap.String("provider", st.Provider),
+			zap.String("from", prev),
+			zap.String("to", st.State),
+			zap.String("last_error", st.LastError))
+	}
+}
+
+// GetAIStatus は AI API の状態を返します。
+func GetAIStatus() AIStatus {
+	statsMutex.RLock()
+	defer statsMutex.RUnlock()
+	return aiStatus
+}
+
 // StartStatsCollector をアプリ起動時に一度呼び出してください。
 func StartStatsCollector(log *zap.Logger) {
 	logger = log
@@ -75,10 +117,19 @@ func refreshStats() {
 func UpdatePlayingStatus(s *discordgo.Session) error {
 	statsMutex.RLock()
 	st := stats
+	ai := aiStatus
 	statsMutex.RUnlock()
 
+	name := fmt.Sprintf("Mem:%.1f%% | CPU:%.1f%% | %s", st.MemoryPercent, st.CPUPercent, st.Hostname)
+	switch ai.State {
+	case AIStateDegraded:
+		name += " | AI:不調"
+	case AIStateOpen:
+		name += " | AI:停止中"
+	}
+
 	activity := &discordgo.Activity{
-			Name: fmt.Sprintf("Mem:%.1f%% | CPU:%.1f%% | %s", st.MemoryPercent, st.CPUPercent, st.Hostname),
+			Name: name,
 			Type: discordgo.ActivityTypeGame,
 	}
 